@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagOutput = "output"
+)
+
+func NewCollectCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:          "collect",
+		Short:        "Collect metrics of nasne once and print them",
+		RunE:         RunCollect,
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().String(flagOutput, "-", "The file to write metrics to. \"-\" means stdout.")
+
+	return cmd
+}
+
+func RunCollect(cmd *cobra.Command, args []string) error {
+	logger, _, err := newLogger(cmd)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("start collect")
+
+	nasneAddr, err := cmd.Flags().GetStringSlice(flagNasneAddr)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagNasneAddr, "value", nasneAddr)
+
+	output, err := cmd.Flags().GetString(flagOutput)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagOutput, "value", output)
+
+	shutdownTracing, err := newTracing(cmd, logger)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	reg := prometheus.NewRegistry()
+
+	collectorNames, err := enabledCollectors(cmd, logger)
+	if err != nil {
+		return err
+	}
+
+	nc, err := collector.NewNasneCollector(logger, nasneAddr, collectorNames)
+	if err != nil {
+		return err
+	}
+	nc.RegisterCollectors(reg)
+
+	httpClient, err := newHTTPClient(cmd, logger, reg)
+	if err != nil {
+		return err
+	}
+	nc.SetHTTPClient(httpClient)
+
+	cache, err := newCache(cmd, logger, reg)
+	if err != nil {
+		return err
+	}
+	nc.SetCache(cache)
+
+	limiter, err := newLimiter(cmd, logger, reg)
+	if err != nil {
+		return err
+	}
+	nc.SetLimiter(limiter)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	collectErr := nc.CollectOnce(ctx)
+
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := collector.WriteMetrics(w, reg); err != nil {
+		return err
+	}
+
+	logger.Debug("end collect")
+	return collectErr
+}
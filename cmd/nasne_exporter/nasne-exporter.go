@@ -1,36 +1,105 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"flag"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/event"
+	"github.com/hatotaka/nasne_exporter/pkg/feed"
+	"github.com/hatotaka/nasne_exporter/pkg/logging"
+	"github.com/hatotaka/nasne_exporter/pkg/mqtt"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/hatotaka/nasne_exporter/pkg/push"
+	"github.com/hatotaka/nasne_exporter/pkg/tracing"
+	"github.com/hatotaka/nasne_exporter/pkg/web"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
 
 const (
-	flagNasneAddr        = "nasne-addr"
-	flagPort             = "port"
-	flagMetricsPath      = "metrics-path"
-	flagDefaultCollector = "default-collector"
+	flagNasneAddr          = "nasne-addr"
+	flagPort               = "port"
+	flagListenAddress      = "web.listen-address"
+	flagSystemdSocket      = "web.systemd-socket"
+	flagMetricsPath        = "metrics-path"
+	flagDefaultCollector   = "default-collector"
+	flagTextfileDir        = "textfile-directory"
+	flagWebConfigFile      = "web-config-file"
+	flagCollectInterval    = "collect-interval"
+	flagCollectIntervals   = "collect-intervals"
+	flagCollectJitter      = "collect-jitter"
+	flagHDDGrowthWindow    = "hdd-growth-window"
+	flagStateDir           = "state-directory"
+	flagCheckpointInterval = "state-checkpoint-interval"
+	flagReadyMinReachable  = "ready-min-reachable"
+	flagLogLevel           = "log.level"
+	flagLogFormat          = "log.format"
+	flagNasneTimeout       = "nasne-timeout"
+	flagOTLPTracesURL      = "otlp-traces-url"
+	flagNasneCacheTTLs     = "nasne-cache-ttls"
+	flagNasneCacheMaxStale = "nasne-cache-max-stale"
+	flagNasneRateLimit     = "nasne-rate-limit"
+	flagNasneRateBurst     = "nasne-rate-burst"
+	flagNasneMaxConcurrent = "nasne-max-concurrent-requests"
+	flagRecordingSlowdown  = "nasne-recording-slowdown"
+
+	// flagCollectorPrefix and flagNoCollectorPrefix are followed by the name of
+	// a collector to enable or disable it.
+	flagCollectorPrefix   = "collector."
+	flagNoCollectorPrefix = "no-collector."
+
+	flagPushgatewayURL        = "pushgateway-url"
+	flagPushgatewayJob        = "pushgateway-job"
+	flagRemoteWriteURL        = "remote-write-url"
+	flagRemoteWriteBufferSize = "remote-write-buffer-size"
+	flagPushRetry             = "push-retry"
+	flagPushTagMapping        = "push-tag-mapping"
+	flagPushBatchSize         = "push-batch-size"
+	flagInfluxDBURL           = "influxdb-url"
+	flagInfluxDBTokenFile     = "influxdb-token-file"
+	flagGraphiteAddress       = "graphite-address"
+	flagGraphitePrefix        = "graphite-prefix"
+
+	flagWebhookURL        = "webhook-url"
+	flagWebhookSecretFile = "webhook-secret-file"
+	flagWebhookEvents     = "webhook-events"
+	flagWebhookRetry      = "webhook-retry"
+
+	flagMQTTURL             = "mqtt-url"
+	flagMQTTClientID        = "mqtt-client-id"
+	flagMQTTUsername        = "mqtt-username"
+	flagMQTTPasswordFile    = "mqtt-password-file"
+	flagMQTTTopicPrefix     = "mqtt-topic-prefix"
+	flagMQTTDiscoveryPrefix = "mqtt-discovery-prefix"
 )
 
-func main() {
-	flag.CommandLine.Parse([]string{})
+const textfileName = "nasne.prom"
 
+// stateFileName is the name of the state file in the state directory.
+const stateFileName = "state.json"
+
+// shutdownTimeout is the time to wait for the HTTP server and the collector
+// to stop after receiving a signal.
+const shutdownTimeout = 5 * time.Second
+
+func main() {
 	c := NewCommand()
 
-	err := c.Execute()
-	if err != nil {
-		glog.Error(err)
+	// The error is printed by cobra.
+	if err := c.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
@@ -42,87 +111,794 @@ func NewCommand() *cobra.Command {
 		RunE:  RunNasneExporter,
 	}
 
-	cmd.Flags().StringSlice(flagNasneAddr, nil, "The address list of nasne.")
-	cmd.Flags().Int(flagPort, 8080, "The port of the endpoint.")
+	cmd.PersistentFlags().StringSlice(flagNasneAddr, nil, "The address list of nasne.")
+	cmd.PersistentFlags().Duration(flagNasneTimeout, 0, "The timeout of each request to nasne. 0 means no timeout.")
+	cmd.PersistentFlags().StringToString(flagNasneCacheTTLs, nil, "The time to cache the responses of each API of nasne, such as \"recorded/titleListGet=10m\".")
+	cmd.PersistentFlags().Duration(flagNasneCacheMaxStale, time.Hour, "The maximum time to serve an expired response from the cache while nasne is unreachable.")
+	cmd.PersistentFlags().Float64(flagNasneRateLimit, 10, "The maximum number of requests per second to each nasne. 0 means no limit.")
+	cmd.PersistentFlags().Int(flagNasneRateBurst, 10, "The maximum burst of requests to each nasne.")
+	cmd.PersistentFlags().Int(flagNasneMaxConcurrent, 2, "The maximum number of concurrent requests to each nasne. 0 means no limit.")
+	cmd.PersistentFlags().Float64(flagRecordingSlowdown, 4, "The factor to divide the rate limit by while nasne is recording.")
+	cmd.PersistentFlags().String(flagOTLPTracesURL, "", "The URL to export traces of collections to with OTLP/HTTP, such as \"http://localhost:4318/v1/traces\".")
+	cmd.PersistentFlags().String(flagLogLevel, "info", "The log level, one of debug, info, warn and error.")
+	cmd.PersistentFlags().String(flagLogFormat, logging.FormatLogfmt, "The log format, one of "+logging.FormatLogfmt+" and "+logging.FormatJSON+".")
+	for _, name := range collector.CollectorNames() {
+		cmd.PersistentFlags().Bool(flagCollectorPrefix+name, collector.IsDefaultEnabled(name), "Enable the "+name+" collector.")
+		cmd.PersistentFlags().Bool(flagNoCollectorPrefix+name, false, "Disable the "+name+" collector.")
+	}
+	cmd.Flags().Int(flagPort, 8080, "The port of the endpoint. Ignored if --"+flagListenAddress+" is set.")
+	cmd.Flags().StringSlice(flagListenAddress, nil, "The address list to listen on, such as \"192.0.2.1:8080\", \"[::1]:8080\" and \"unix:/run/nasne_exporter.sock\".")
+	cmd.Flags().Bool(flagSystemdSocket, false, "Use the sockets passed by systemd socket activation instead of listening.")
 	cmd.Flags().String(flagMetricsPath, "/metrics", "The path of metrics.")
 	cmd.Flags().Bool(flagDefaultCollector, true, "Enable prometheus/client_go default collecter (ProcessCollector and GoCollectora)")
+	cmd.Flags().Duration(flagCollectInterval, time.Minute, "The interval of collection.")
+	cmd.Flags().StringToString(flagCollectIntervals, nil, "The interval of collection for each collector, such as \"tuner=10s,recorded=10m\". Prefix the collector with the address of nasne and \"/\" to set it for the nasne, such as \"192.0.2.1/tuner=30s\".")
+	cmd.Flags().Duration(flagCollectJitter, 0, "The maximum random delay added to each collection.")
+	cmd.Flags().Duration(flagHDDGrowthWindow, 24*time.Hour, "The length of the window to estimate the growth rate of HDD usage.")
+	cmd.Flags().String(flagStateDir, "", "The directory to persist the state of collectors, such as the counters of recordings, across restarts.")
+	cmd.Flags().Duration(flagCheckpointInterval, 5*time.Minute, "The interval of saving the state to --"+flagStateDir+".")
+	cmd.Flags().Int(flagReadyMinReachable, 1, "The minimum number of reachable nasnes for /-/ready to report ready.")
+	cmd.Flags().String(flagWebConfigFile, "", "The path to the web config file to enable TLS and basic authentication.")
+	cmd.Flags().String(flagPushgatewayURL, "", "The URL of Pushgateway to push metrics to after each collection.")
+	cmd.Flags().String(flagPushgatewayJob, "nasne_exporter", "The job name used to push metrics to Pushgateway.")
+	cmd.Flags().String(flagRemoteWriteURL, "", "The URL to send metrics to with the Prometheus remote write protocol after each collection.")
+	cmd.Flags().Int(flagRemoteWriteBufferSize, 10000, "The maximum number of series buffered while the remote write endpoint is unreachable.")
+	cmd.Flags().Int(flagPushRetry, 3, "The number of retries of a failed push.")
+	cmd.Flags().String(flagInfluxDBURL, "", "The URL of the write API of InfluxDB to write metrics to after each collection, such as \"http://localhost:8086/api/v2/write?org=home&bucket=nasne\".")
+	cmd.Flags().String(flagInfluxDBTokenFile, "", "The path to the file of the API token of InfluxDB.")
+	cmd.Flags().String(flagGraphiteAddress, "", "The address of Graphite to send metrics to in the plaintext protocol after each collection, such as \"localhost:2003\".")
+	cmd.Flags().String(flagGraphitePrefix, "", "The prefix of the metric names sent to Graphite.")
+	cmd.Flags().StringToString(flagPushTagMapping, nil, "The mapping from labels to the tags of InfluxDB and Graphite, such as \"name=box,id=hdd_id\". Labels mapped to the empty string are dropped.")
+	cmd.Flags().Int(flagPushBatchSize, 5000, "The maximum number of lines sent to InfluxDB and Graphite at once.")
+	cmd.Flags().StringSlice(flagWebhookURL, nil, "The URL list to post events of nasne to.")
+	cmd.Flags().String(flagWebhookSecretFile, "", "The path to the file of the secret to sign the webhook requests with HMAC-SHA256.")
+	cmd.Flags().StringSlice(flagWebhookEvents, nil, "The types of events to post to webhooks. All types are posted if empty.")
+	cmd.Flags().Int(flagWebhookRetry, 3, "The number of retries of a failed webhook request.")
+	cmd.Flags().String(flagMQTTURL, "", "The URL of the MQTT broker to publish the state of nasne to, such as \"tcp://192.0.2.1:1883\" and \"ssl://192.0.2.1:8883\".")
+	cmd.Flags().String(flagMQTTClientID, "nasne_exporter", "The client ID of MQTT.")
+	cmd.Flags().String(flagMQTTUsername, "", "The user name of MQTT.")
+	cmd.Flags().String(flagMQTTPasswordFile, "", "The path to the file of the password of MQTT.")
+	cmd.Flags().String(flagMQTTTopicPrefix, "nasne", "The prefix of MQTT topics.")
+	cmd.Flags().String(flagMQTTDiscoveryPrefix, "homeassistant", "The discovery prefix of Home Assistant. The discovery is disabled if empty.")
+	cmd.Flags().String(flagTextfileDir, "", "The directory to write "+textfileName+" for the textfile collector of node_exporter. If set, the HTTP server is not started.")
 
-	flag.Lookup("logtostderr").Value.Set("true")
-	cmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+	cmd.AddCommand(NewCollectCommand())
 
 	return cmd
 }
 
 func RunNasneExporter(cmd *cobra.Command, args []string) error {
-	glog.V(2).Info("start nasne_exporter")
+	logger, logLevel, err := newLogger(cmd)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("start nasne_exporter")
 
 	nasneAddr, err := cmd.Flags().GetStringSlice(flagNasneAddr)
 	if err != nil {
 		return err
 	}
-	glog.V(2).Infof("%v = %v", flagNasneAddr, nasneAddr)
+	logger.Debug("flag", "name", flagNasneAddr, "value", nasneAddr)
 
 	port, err := cmd.Flags().GetInt(flagPort)
 	if err != nil {
 		return err
 	}
-	glog.V(2).Infof("%v = %v", flagPort, port)
+	logger.Debug("flag", "name", flagPort, "value", port)
+
+	listenAddress, err := cmd.Flags().GetStringSlice(flagListenAddress)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagListenAddress, "value", listenAddress)
+
+	systemdSocket, err := cmd.Flags().GetBool(flagSystemdSocket)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagSystemdSocket, "value", systemdSocket)
 
 	metricsPath, err := cmd.Flags().GetString(flagMetricsPath)
 	if err != nil {
 		return err
 	}
-	glog.V(2).Infof("%v = %v", flagMetricsPath, metricsPath)
+	logger.Debug("flag", "name", flagMetricsPath, "value", metricsPath)
+
+	hddGrowthWindow, err := cmd.Flags().GetDuration(flagHDDGrowthWindow)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagHDDGrowthWindow, "value", hddGrowthWindow)
+
+	stateDir, err := cmd.Flags().GetString(flagStateDir)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagStateDir, "value", stateDir)
+
+	checkpointInterval, err := cmd.Flags().GetDuration(flagCheckpointInterval)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagCheckpointInterval, "value", checkpointInterval)
+
+	readyMinReachable, err := cmd.Flags().GetInt(flagReadyMinReachable)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagReadyMinReachable, "value", readyMinReachable)
 
 	defaultCollector, err := cmd.Flags().GetBool(flagDefaultCollector)
 	if err != nil {
 		return err
 	}
-	glog.V(2).Infof("%v = %v", flagDefaultCollector, defaultCollector)
+	logger.Debug("flag", "name", flagDefaultCollector, "value", defaultCollector)
+
+	textfileDir, err := cmd.Flags().GetString(flagTextfileDir)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagTextfileDir, "value", textfileDir)
+
+	webConfigFile, err := cmd.Flags().GetString(flagWebConfigFile)
+	if err != nil {
+		return err
+	}
+	logger.Debug("flag", "name", flagWebConfigFile, "value", webConfigFile)
+
+	webConfig := &web.Config{}
+	if webConfigFile != "" {
+		webConfig, err = web.LoadConfig(webConfigFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	tlsConfig, err := webConfig.TLS()
+	if err != nil {
+		return err
+	}
+
+	schedule, err := newSchedule(cmd, logger)
+	if err != nil {
+		return err
+	}
+
+	pushers, err := newPushers(cmd, logger)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := newWebhook(cmd, logger)
+	if err != nil {
+		return err
+	}
+
+	mqttPublisher, err := newMQTTPublisher(cmd, logger)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := newTracing(cmd, logger)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
 
 	reg := prometheus.NewRegistry()
 
-	nc := collector.NewNasneCollector(nasneAddr)
-	go nc.Run()
+	collectorNames, err := enabledCollectors(cmd, logger)
+	if err != nil {
+		return err
+	}
+
+	nc, err := collector.NewNasneCollector(logger, nasneAddr, collectorNames)
+	if err != nil {
+		return err
+	}
+	if err := nc.SetSchedule(schedule); err != nil {
+		return err
+	}
+	if err := nc.SetHDDGrowthWindow(hddGrowthWindow); err != nil {
+		return err
+	}
+	var detector *event.Detector
+	if webhook != nil {
+		detector = event.NewDetector()
+	}
+	if stateDir != "" {
+		if err := os.MkdirAll(stateDir, 0700); err != nil {
+			return err
+		}
+		if err := nc.SetStateFile(filepath.Join(stateDir, stateFileName), checkpointInterval); err != nil {
+			return err
+		}
+		if detector != nil {
+			nc.AddState("events", detector)
+		}
+		if err := nc.LoadState(); err != nil {
+			return err
+		}
+	}
 	nc.RegisterCollectors(reg)
 
-	if defaultCollector {
-		reg.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
-		reg.MustRegister(prometheus.NewGoCollector())
+	httpClient, err := newHTTPClient(cmd, logger, reg)
+	if err != nil {
+		return err
+	}
+	nc.SetHTTPClient(httpClient)
+
+	cache, err := newCache(cmd, logger, reg)
+	if err != nil {
+		return err
+	}
+	nc.SetCache(cache)
+
+	limiter, err := newLimiter(cmd, logger, reg)
+	if err != nil {
+		return err
+	}
+	nc.SetLimiter(limiter)
+
+	if webhook != nil {
+		reg.MustRegister(detector.Collectors()...)
+		reg.MustRegister(webhook.Collectors()...)
+
+		nc.AddSnapshotHook(func(ctx context.Context, s *collector.Snapshot) {
+			for _, e := range detector.Detect(s) {
+				webhook.Send(e)
+			}
+		})
+	}
+
+	feeds := feed.NewStore()
+	nc.AddSnapshotHook(func(ctx context.Context, s *collector.Snapshot) {
+		feeds.Update(s)
+	})
+
+	if mqttPublisher != nil {
+		reg.MustRegister(mqttPublisher.Collectors()...)
+
+		nc.AddSnapshotHook(func(ctx context.Context, s *collector.Snapshot) {
+			mqttPublisher.Update(s)
+		})
+	}
+
+	// Pushes run on their own goroutines so that a slow receiver does not
+	// block the collection, and are canceled before the next collection.
+	var pushQueues []*push.Queue
+	for _, p := range pushers {
+		q := push.NewQueue(logger, p, reg, schedule.Interval)
+		pushQueues = append(pushQueues, q)
+		nc.AddHook(func(ctx context.Context) {
+			q.Notify()
+		})
+	}
+
+	// node_exporter exports its own process and Go metrics, so the default
+	// collectors would conflict with them in the textfile.
+	if defaultCollector && textfileDir == "" {
+		reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		reg.MustRegister(collectors.NewGoCollector())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if webhook != nil {
+		go webhook.Run(ctx)
+	}
+	if mqttPublisher != nil {
+		go mqttPublisher.Run(ctx)
+	}
+	for _, q := range pushQueues {
+		go q.Run(ctx)
+	}
+
+	collectorDone := make(chan struct{})
+	runCollector := func() {
+		go func() {
+			defer close(collectorDone)
+			nc.Run(ctx)
+		}()
 	}
 
+	if textfileDir != "" {
+		path := filepath.Join(textfileDir, textfileName)
+		nc.AddHook(func(ctx context.Context) {
+			if err := collector.WriteTextfile(path, reg); err != nil {
+				logger.Error("failed to write textfile", "path", path, "err", err)
+			}
+		})
+		runCollector()
+
+		<-ctx.Done()
+		logger.Info("shutting down nasne_exporter")
+
+		waitCollector(logger, collectorDone, shutdownTimeout)
+
+		logger.Debug("stop nasne_exporter")
+		return nil
+	}
+
+	if len(listenAddress) == 0 {
+		listenAddress = []string{fmt.Sprintf(":%d", port)}
+	}
+
+	var ls []net.Listener
+	if systemdSocket {
+		ls, err = web.SystemdListeners()
+	} else {
+		ls, err = web.Listen(listenAddress)
+	}
+	if err != nil {
+		return err
+	}
+	for _, l := range ls {
+		logger.Info("listen", "addr", l.Addr())
+	}
+
+	runCollector()
+
 	mux := http.NewServeMux()
-	mux.Handle(metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle(metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	}))
+	mux.Handle("/-/healthy", collector.HealthyHandler())
+	mux.Handle("/-/ready", nc.ReadyHandler(readyMinReachable))
+	mux.Handle("/-/log-level", logging.LevelHandler(logLevel, logger))
+	mux.Handle("/calendar.ics", feeds.CalendarHandler())
+	mux.Handle("/feeds/recorded.atom", feeds.AtomHandler())
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Handler:   webConfig.Handler(mux, logger),
+		TLSConfig: tlsConfig,
 	}
 
-	errCh := make(chan error, 0)
-	defer close(errCh)
-
+	errCh := make(chan error, 1)
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			errCh <- err
-		}
+		errCh <- web.Serve(srv, ls)
 	}()
 
-	sigCh := make(chan os.Signal, 0)
-	defer close(sigCh)
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down nasne_exporter")
+	case serveErr = <-errCh:
+		logger.Error("server failed", "err", serveErr)
+	}
 
-	signal.Notify(sigCh, os.Interrupt)
+	// Stop the collector and cancel the requests to nasne in flight.
+	stop()
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down server", "err", err)
+	}
+
+	waitCollector(logger, collectorDone, shutdownTimeout)
+
+	logger.Debug("stop nasne_exporter")
+	return serveErr
+}
+
+// waitCollector waits for the collector to stop up to timeout.
+func waitCollector(logger *slog.Logger, done <-chan struct{}, timeout time.Duration) {
 	select {
-	case <-sigCh:
-		ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-		if err := srv.Shutdown(ctx); err != nil {
-			glog.Error(err)
+	case <-done:
+	case <-time.After(timeout):
+		logger.Warn("collector did not stop in time", "timeout", timeout)
+	}
+}
+
+// newLogger returns a logger configured by the --log.level and --log.format
+// flags, and its level which can be changed at runtime.
+func newLogger(cmd *cobra.Command) (*slog.Logger, *slog.LevelVar, error) {
+	levelFlag, err := cmd.Flags().GetString(flagLogLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format, err := cmd.Flags().GetString(flagLogFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := logging.ParseLevel(levelFlag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	level := &slog.LevelVar{}
+	level.Set(l)
+
+	logger, err := logging.New(os.Stderr, level, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return logger, level, nil
+}
+
+// newCache returns the cache of the responses of nasne, whose metrics are
+// registered to reg.
+func newCache(cmd *cobra.Command, logger *slog.Logger, reg prometheus.Registerer) (*nasneclient.Cache, error) {
+	ttlsFlag, err := cmd.Flags().GetStringToString(flagNasneCacheTTLs)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagNasneCacheTTLs, "value", ttlsFlag)
+
+	maxStale, err := cmd.Flags().GetDuration(flagNasneCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagNasneCacheMaxStale, "value", maxStale)
+
+	ttls := map[string]time.Duration{}
+	for endpoint, v := range ttlsFlag {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", flagNasneCacheTTLs, err)
+		}
+		ttls[endpoint] = d
+	}
+
+	c := nasneclient.NewCache(ttls, maxStale)
+	reg.MustRegister(c.Collectors()...)
+
+	return c, nil
+}
+
+// newLimiter returns the limiter of the requests to nasne, whose metrics are
+// registered to reg.
+func newLimiter(cmd *cobra.Command, logger *slog.Logger, reg prometheus.Registerer) (*nasneclient.Limiter, error) {
+	rate, err := cmd.Flags().GetFloat64(flagNasneRateLimit)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagNasneRateLimit, "value", rate)
+
+	burst, err := cmd.Flags().GetInt(flagNasneRateBurst)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagNasneRateBurst, "value", burst)
+
+	maxConcurrent, err := cmd.Flags().GetInt(flagNasneMaxConcurrent)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagNasneMaxConcurrent, "value", maxConcurrent)
+
+	recordingSlowdown, err := cmd.Flags().GetFloat64(flagRecordingSlowdown)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagRecordingSlowdown, "value", recordingSlowdown)
+
+	if rate < 0 || maxConcurrent < 0 {
+		return nil, fmt.Errorf("%v and %v must not be negative", flagNasneRateLimit, flagNasneMaxConcurrent)
+	}
+
+	l := nasneclient.NewLimiter(rate, burst, maxConcurrent, recordingSlowdown)
+	reg.MustRegister(l.Collectors()...)
+
+	return l, nil
+}
+
+// newTracing sets up tracing if the --otlp-traces-url flag is set. The returned
+// function flushes the spans.
+func newTracing(cmd *cobra.Command, logger *slog.Logger) (func(), error) {
+	url, err := cmd.Flags().GetString(flagOTLPTracesURL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagOTLPTracesURL, "value", url)
+
+	if url == "" {
+		return func() {}, nil
+	}
+
+	tp := tracing.NewTracerProvider(tracing.NewOTLPExporter(logger, url))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := tp.Shutdown(ctx); err != nil {
+			logger.Error("failed to shut down tracing", "err", err)
+		}
+	}, nil
+}
+
+// newHTTPClient returns the client used to send requests to nasne, which is
+// instrumented with the metrics registered to reg.
+func newHTTPClient(cmd *cobra.Command, logger *slog.Logger, reg prometheus.Registerer) (*http.Client, error) {
+	timeout, err := cmd.Flags().GetDuration(flagNasneTimeout)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagNasneTimeout, "value", timeout)
+
+	m := nasneclient.NewClientMetrics()
+	reg.MustRegister(m.Collectors()...)
+
+	return &http.Client{
+		Transport: m.InstrumentRoundTripper(http.DefaultTransport),
+		Timeout:   timeout,
+	}, nil
+}
+
+// enabledCollectors returns the names of the collectors enabled by the
+// --collector.<name> and --no-collector.<name> flags.
+func enabledCollectors(cmd *cobra.Command, logger *slog.Logger) ([]string, error) {
+	var names []string
+	for _, name := range collector.CollectorNames() {
+		enabled, err := cmd.Flags().GetBool(flagCollectorPrefix + name)
+		if err != nil {
+			return nil, err
+		}
+
+		disabled, err := cmd.Flags().GetBool(flagNoCollectorPrefix + name)
+		if err != nil {
+			return nil, err
+		}
+
+		if enabled && !disabled {
+			names = append(names, name)
+		}
+	}
+	logger.Debug("enabled collectors", "collectors", names)
+
+	return names, nil
+}
+
+func newSchedule(cmd *cobra.Command, logger *slog.Logger) (collector.Schedule, error) {
+	interval, err := cmd.Flags().GetDuration(flagCollectInterval)
+	if err != nil {
+		return collector.Schedule{}, err
+	}
+	logger.Debug("flag", "name", flagCollectInterval, "value", interval)
+
+	intervalsFlag, err := cmd.Flags().GetStringToString(flagCollectIntervals)
+	if err != nil {
+		return collector.Schedule{}, err
+	}
+	logger.Debug("flag", "name", flagCollectIntervals, "value", intervalsFlag)
+
+	jitter, err := cmd.Flags().GetDuration(flagCollectJitter)
+	if err != nil {
+		return collector.Schedule{}, err
+	}
+	logger.Debug("flag", "name", flagCollectJitter, "value", jitter)
+
+	intervals := map[string]time.Duration{}
+	for name, v := range intervalsFlag {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return collector.Schedule{}, fmt.Errorf("invalid %v: %v", flagCollectIntervals, err)
+		}
+		intervals[name] = d
+	}
+
+	return collector.Schedule{
+		Interval:  interval,
+		Intervals: intervals,
+		Jitter:    jitter,
+	}, nil
+}
+
+func newPushers(cmd *cobra.Command, logger *slog.Logger) ([]push.Pusher, error) {
+	pushgatewayURL, err := cmd.Flags().GetString(flagPushgatewayURL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagPushgatewayURL, "value", pushgatewayURL)
+
+	pushgatewayJob, err := cmd.Flags().GetString(flagPushgatewayJob)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagPushgatewayJob, "value", pushgatewayJob)
+
+	remoteWriteURL, err := cmd.Flags().GetString(flagRemoteWriteURL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagRemoteWriteURL, "value", remoteWriteURL)
+
+	remoteWriteBufferSize, err := cmd.Flags().GetInt(flagRemoteWriteBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagRemoteWriteBufferSize, "value", remoteWriteBufferSize)
+
+	if remoteWriteBufferSize < 1 {
+		return nil, fmt.Errorf("invalid %v: must be positive: %v", flagRemoteWriteBufferSize, remoteWriteBufferSize)
+	}
+
+	pushRetry, err := cmd.Flags().GetInt(flagPushRetry)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagPushRetry, "value", pushRetry)
+
+	influxDBURL, err := cmd.Flags().GetString(flagInfluxDBURL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagInfluxDBURL, "value", influxDBURL)
+
+	influxDBTokenFile, err := cmd.Flags().GetString(flagInfluxDBTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagInfluxDBTokenFile, "value", influxDBTokenFile)
+
+	graphiteAddress, err := cmd.Flags().GetString(flagGraphiteAddress)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagGraphiteAddress, "value", graphiteAddress)
+
+	graphitePrefix, err := cmd.Flags().GetString(flagGraphitePrefix)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagGraphitePrefix, "value", graphitePrefix)
+
+	tagMapping, err := cmd.Flags().GetStringToString(flagPushTagMapping)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagPushTagMapping, "value", tagMapping)
+
+	batchSize, err := cmd.Flags().GetInt(flagPushBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagPushBatchSize, "value", batchSize)
+
+	var pushers []push.Pusher
+	if pushgatewayURL != "" {
+		pushers = append(pushers, push.NewPushgateway(logger, pushgatewayURL, pushgatewayJob, pushRetry))
+	}
+	if remoteWriteURL != "" {
+		pushers = append(pushers, push.NewRemoteWriter(logger, remoteWriteURL, pushRetry, remoteWriteBufferSize))
+	}
+	if influxDBURL != "" {
+		var token string
+		if influxDBTokenFile != "" {
+			b, err := ioutil.ReadFile(influxDBTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			token = string(bytes.TrimSpace(b))
+		}
+		pushers = append(pushers, push.NewInfluxDB(logger, influxDBURL, token, tagMapping, pushRetry, batchSize))
+	}
+	if graphiteAddress != "" {
+		pushers = append(pushers, push.NewGraphite(logger, graphiteAddress, graphitePrefix, tagMapping, pushRetry, batchSize))
+	}
+
+	return pushers, nil
+}
+
+// newWebhook returns the webhook to post events to, or nil if no webhook is
+// configured.
+func newWebhook(cmd *cobra.Command, logger *slog.Logger) (*event.Webhook, error) {
+	urls, err := cmd.Flags().GetStringSlice(flagWebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagWebhookURL, "value", urls)
+
+	secretFile, err := cmd.Flags().GetString(flagWebhookSecretFile)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagWebhookSecretFile, "value", secretFile)
+
+	eventsFlag, err := cmd.Flags().GetStringSlice(flagWebhookEvents)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagWebhookEvents, "value", eventsFlag)
+
+	retry, err := cmd.Flags().GetInt(flagWebhookRetry)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagWebhookRetry, "value", retry)
+
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	var types []event.Type
+	for _, e := range eventsFlag {
+		t, err := event.ParseType(e)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+
+	var secret []byte
+	if secretFile != "" {
+		b, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(b)
+	}
+
+	return event.NewWebhook(logger, urls, secret, types, retry), nil
+}
+
+// newMQTTPublisher returns the publisher of the state of nasne to MQTT, or nil
+// if no broker is configured.
+func newMQTTPublisher(cmd *cobra.Command, logger *slog.Logger) (*mqtt.Publisher, error) {
+	url, err := cmd.Flags().GetString(flagMQTTURL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagMQTTURL, "value", url)
+
+	clientID, err := cmd.Flags().GetString(flagMQTTClientID)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagMQTTClientID, "value", clientID)
+
+	username, err := cmd.Flags().GetString(flagMQTTUsername)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagMQTTUsername, "value", username)
+
+	topicPrefix, err := cmd.Flags().GetString(flagMQTTTopicPrefix)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagMQTTTopicPrefix, "value", topicPrefix)
+
+	discoveryPrefix, err := cmd.Flags().GetString(flagMQTTDiscoveryPrefix)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagMQTTDiscoveryPrefix, "value", discoveryPrefix)
+
+	passwordFile, err := cmd.Flags().GetString(flagMQTTPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("flag", "name", flagMQTTPasswordFile, "value", passwordFile)
+
+	if url == "" {
+		return nil, nil
+	}
+
+	var password string
+	if passwordFile != "" {
+		b, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
 		}
-	case err := <-errCh:
-		glog.Error(err)
+		password = string(bytes.TrimSpace(b))
 	}
 
-	glog.V(2).Info("stop nasne_exporter")
-	return nil
+	return mqtt.NewPublisher(logger, mqtt.Config{
+		URL:             url,
+		ClientID:        clientID,
+		Username:        username,
+		Password:        password,
+		TopicPrefix:     topicPrefix,
+		DiscoveryPrefix: discoveryPrefix,
+	}), nil
 }
@@ -1,384 +1,392 @@
 package collector
 
 import (
-	"strconv"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/golang/glog"
 	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/hatotaka/nasne_exporter/pkg/collector")
+
 const (
 	namespace = "nasne"
 
-	labelName            = "name"
-	labelID              = "id"
-	labelFormat          = "format"
-	labelSoftwareVersion = "software_version"
-	labelHardwareVersion = "hardware_version"
-	labelProductName     = "product_name"
-	labelHDDName         = "hdd_name"
-	labelVendorID        = "vendor_id"
-	labelProductID       = "product_id"
+	labelName             = "name"
+	labelID               = "id"
+	labelFormat           = "format"
+	labelSoftwareVersion  = "software_version"
+	labelHardwareVersion  = "hardware_version"
+	labelProductName      = "product_name"
+	labelHDDName          = "hdd_name"
+	labelVendorID         = "vendor_id"
+	labelProductID        = "product_id"
+	labelSerialNumber     = "serial_number"
+	labelInternal         = "internal"
+	labelBroadcastingType = "broadcasting_type"
+
+	labelAddr      = "addr"
+	labelCollector = "collector"
+	labelRequestID = "request_id"
 )
 
-func NewNasneCollector(nasneAddrs []string) *NasneCollector {
+// NewNasneCollector returns a NasneCollector which collects the metrics of
+// nasneAddrs with the collectors named collectorNames.
+func NewNasneCollector(logger *slog.Logger, nasneAddrs []string, collectorNames []string) (*NasneCollector, error) {
+	collectors, err := newCollectors(collectorNames)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NasneCollector{
+		logger:     logger,
 		nasneAddrs: nasneAddrs,
+		collectors: collectors,
+		schedule:   Schedule{Interval: time.Minute},
 
-		infoGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      "info",
-				Help:      "Information of nasne.",
-			},
-			[]string{
-				labelName,
-				labelSoftwareVersion,
-				labelHardwareVersion,
-				labelProductName,
-			},
-		),
-		hddSizeBytesGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      "hdd_size_bytes",
-				Help:      "HDD size in bytes.",
-			},
-			[]string{
-				labelName,
-				labelID,
-				labelFormat,
-				labelHDDName,
-				labelVendorID,
-				labelProductID,
-			},
-		),
-		hddUsageBytesGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      "hdd_usage_bytes",
-				Help:      "HDD usage in bytes.",
-			},
-			[]string{
-				labelName,
-				labelID,
-				labelFormat,
-				labelHDDName,
-				labelVendorID,
-				labelProductID,
-			},
-		),
-		dtcpipClientsGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      "dtcpip_clients",
-				Help:      "Number of clients connected with DTCP-IP.",
-			},
-			[]string{
-				labelName,
-			},
-		),
-		recordingsGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      "recordings",
-				Help:      "Number of recordings.",
-			},
-			[]string{
-				labelName,
-			},
-		),
-		recordedTitlesGauge: prometheus.NewGaugeVec(
+		lastCollectTileGauge: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
-				Name:      "recorded_titles",
-				Help:      "Number of recorded titles.",
-			},
-			[]string{
-				labelName,
+				Name:      "last_collect_time",
+				Help:      "Time of last collect metrics of nasne.",
 			},
+			[]string{},
 		),
-		reservedTitlesGauge: prometheus.NewGaugeVec(
+		nextCollectTimeGauge: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
-				Name:      "reserved_titles",
-				Help:      "Number of reserved titles.",
+				Name:      "next_collect_time",
+				Help:      "Time of next scheduled collect of each collector.",
 			},
 			[]string{
-				labelName,
+				labelAddr,
+				labelCollector,
 			},
 		),
-		reservedConflictTitlesGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
+		collectDurationSecondsHistogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
 				Namespace: namespace,
-				Name:      "reserved_conflict_titles",
-				Help:      "Number of Conflicting titles.",
+				Name:      "collect_duration_seconds",
+				Help:      "Collection latency distributions.",
+				Buckets:   prometheus.LinearBuckets(1, 1, 10),
 			},
 			[]string{
 				labelName,
 			},
 		),
-		reservedNotFoundTitlesGauge: prometheus.NewGaugeVec(
+		collectorDurationSecondsGauge: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
-				Name:      "reserved_notfound_titles",
-				Help:      "Number of titles that could not be found.",
+				Name:      "collector_duration_seconds",
+				Help:      "Duration of the last collection of each collector.",
 			},
 			[]string{
 				labelName,
+				labelCollector,
 			},
 		),
-		lastCollectTileGauge: prometheus.NewGaugeVec(
+		collectorSuccessGauge: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
-				Name:      "last_collect_time",
-				Help:      "Time of last collect metrics of nasne.",
-			},
-			[]string{},
-		),
-		collectDurationSecondsHistogram: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Namespace: namespace,
-				Name:      "collect_duration_seconds",
-				Help:      "Collection latency distributions.",
-				Buckets:   prometheus.LinearBuckets(1, 1, 10),
+				Name:      "collector_success",
+				Help:      "Whether the last collection of each collector succeeded.",
 			},
 			[]string{
 				labelName,
+				labelCollector,
 			},
 		),
-	}
+	}, nil
 }
 
 type NasneCollector struct {
+	logger     *slog.Logger
+	httpClient *http.Client
+	cache      *nasneclient.Cache
+	limiter    *nasneclient.Limiter
 	nasneAddrs []string
+	collectors []namedCollector
+	hooks      []func(ctx context.Context)
+	schedule   Schedule
+	status     statusStore
+
+	snapshotHooks []func(ctx context.Context, s *Snapshot)
+
+	statePath          string
+	checkpointInterval time.Duration
+	states             []namedState
+	stateMu            sync.Mutex
 
-	infoGauge                       *prometheus.GaugeVec
-	hddSizeBytesGauge               *prometheus.GaugeVec
-	hddUsageBytesGauge              *prometheus.GaugeVec
-	dtcpipClientsGauge              *prometheus.GaugeVec
-	recordingsGauge                 *prometheus.GaugeVec
-	recordedTitlesGauge             *prometheus.GaugeVec
-	reservedTitlesGauge             *prometheus.GaugeVec
-	reservedConflictTitlesGauge     *prometheus.GaugeVec
-	reservedNotFoundTitlesGauge     *prometheus.GaugeVec
 	lastCollectTileGauge            *prometheus.GaugeVec
+	nextCollectTimeGauge            *prometheus.GaugeVec
 	collectDurationSecondsHistogram *prometheus.HistogramVec
+	collectorDurationSecondsGauge   *prometheus.GaugeVec
+	collectorSuccessGauge           *prometheus.GaugeVec
 }
 
 func (n *NasneCollector) RegisterCollectors(r *prometheus.Registry) {
 	r.MustRegister(
-		n.infoGauge,
-		n.hddSizeBytesGauge,
-		n.hddUsageBytesGauge,
-		n.dtcpipClientsGauge,
-		n.recordingsGauge,
-		n.recordedTitlesGauge,
-		n.reservedTitlesGauge,
-		n.reservedConflictTitlesGauge,
-		n.reservedNotFoundTitlesGauge,
 		n.lastCollectTileGauge,
+		n.nextCollectTimeGauge,
 		n.collectDurationSecondsHistogram,
+		n.collectorDurationSecondsGauge,
+		n.collectorSuccessGauge,
 	)
+
+	for _, c := range n.collectors {
+		r.MustRegister(c.collector.Collectors()...)
+	}
 }
 
-func (n *NasneCollector) Run() error {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
+// SetHTTPClient sets the client used to send requests to nasne.
+func (n *NasneCollector) SetHTTPClient(c *http.Client) {
+	n.httpClient = c
+}
 
-	n.runCollect()
+// SetCache sets the cache of the responses of nasne.
+func (n *NasneCollector) SetCache(c *nasneclient.Cache) {
+	n.cache = c
+}
 
-	for t := range ticker.C {
-		glog.V(2).Info(t)
+// SetLimiter sets the limiter of the requests to nasne.
+func (n *NasneCollector) SetLimiter(l *nasneclient.Limiter) {
+	n.limiter = l
+}
 
-		n.runCollect()
+// SetHDDGrowthWindow sets the length of the window to estimate the growth
+// rate of HDD usage.
+func (n *NasneCollector) SetHDDGrowthWindow(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("HDD growth window must be positive: %v", d)
 	}
 
+	for _, c := range n.collectors {
+		if hc, ok := c.collector.(*hddCollector); ok {
+			hc.growth = newHDDGrowth(d)
+		}
+	}
 	return nil
 }
 
-func (n *NasneCollector) collectCollectionDuration(start, end time.Time, commonLabel prometheus.Labels) error {
-	n.collectDurationSecondsHistogram.With(commonLabel).Observe(end.Sub(start).Seconds())
-	return nil
+// AddHook adds a function which is called after each collection pass in Run.
+func (n *NasneCollector) AddHook(h func(ctx context.Context)) {
+	n.hooks = append(n.hooks, h)
 }
 
-func (n *NasneCollector) collectInfo(client *nasneclient.NasneClient, commonLabel prometheus.Labels) error {
-	softwareVersion, err := client.GetSoftwareVersion()
-	if err != nil {
-		return err
-	}
+// CollectOnce runs a single collection pass against all nasnes.
+// It returns an error if any nasne could not be collected completely.
+func (n *NasneCollector) CollectOnce(ctx context.Context) error {
+	return n.runCollect(ctx)
+}
 
-	hardwareVersion, err := client.GetHardwareVersion()
-	if err != nil {
-		return err
+func (n *NasneCollector) collectCollectionDuration(start, end time.Time, commonLabel prometheus.Labels, ip, requestID string) error {
+	// The exemplar links a slow collection to the log lines of the request.
+	exemplar := prometheus.Labels{
+		labelAddr:      ip,
+		labelRequestID: requestID,
 	}
 
-	labels := prometheus.Labels{
-		labelSoftwareVersion: softwareVersion.SoftwareVersion,
-		labelHardwareVersion: strconv.Itoa(hardwareVersion.HardwareVersion),
-		labelProductName:     hardwareVersion.ProductName,
+	// ObserveWithExemplar panics if the labels are too long, so the name is
+	// truncated to the rest of the limit, and dropped if nothing is left. The
+	// exemplar is dropped if the address alone is too long.
+	rest := prometheus.ExemplarMaxRunes
+	for k, v := range exemplar {
+		rest -= utf8.RuneCountInString(k) + utf8.RuneCountInString(v)
+	}
+	observer := n.collectDurationSecondsHistogram.With(commonLabel)
+	if rest < 0 {
+		observer.Observe(end.Sub(start).Seconds())
+		return nil
+	}
+	if rest -= utf8.RuneCountInString(labelName); rest > 0 {
+		exemplar[labelName] = truncateRunes(commonLabel[labelName], rest)
 	}
 
-	n.infoGauge.With(mergeLabels(commonLabel, labels)).Set(1)
-
+	observer.(prometheus.ExemplarObserver).ObserveWithExemplar(end.Sub(start).Seconds(), exemplar)
 	return nil
 }
 
-func (n *NasneCollector) collectHDD(client *nasneclient.NasneClient, commonLabel prometheus.Labels) error {
-	hddList, err := client.GetHDDList()
+func (n *NasneCollector) getCommonLabel(ctx context.Context, client *nasneclient.NasneClient) (prometheus.Labels, error) {
+	bn, err := client.GetBoxName(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, hdd := range hddList.HDD {
-		hddInfo, err := client.GetHDDInfo(hdd.ID)
-		if err != nil {
-			glog.Fatal(err)
+	return prometheus.Labels{
+		labelName: bn.Name,
+	}, nil
+}
+
+func (n *NasneCollector) runCollect(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "collect", trace.WithNewRoot())
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	n.logger.Debug("start collect")
+
+	var failed int
+	for _, ip := range n.nasneAddrs {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		labels := prometheus.Labels{
-			labelID:        strconv.Itoa(hddInfo.HDD.ID),
-			labelFormat:    hddInfo.HDD.Format,
-			labelHDDName:   hddInfo.HDD.Name,
-			labelVendorID:  hddInfo.HDD.VendorID,
-			labelProductID: hddInfo.HDD.ProductID,
+		if err := n.collectNasne(ctx, ip, n.collectors); err != nil {
+			n.logger.Error("collect failed", "box", ip, "err", err)
+			failed++
 		}
+	}
 
-		n.hddSizeBytesGauge.With(mergeLabels(commonLabel, labels)).Set(hddInfo.HDD.TotalVolumeSize)
-		n.hddUsageBytesGauge.With(mergeLabels(commonLabel, labels)).Set(hddInfo.HDD.UsedVolumeSize)
+	n.logger.Debug("end collect")
+
+	if failed > 0 {
+		return fmt.Errorf("failed to collect %d of %d nasne(s)", failed, len(n.nasneAddrs))
 	}
 
 	return nil
 }
 
-func (n *NasneCollector) collectDTCPClient(client *nasneclient.NasneClient, commonLabel prometheus.Labels) error {
-	dtcpipClientList, err := client.GetDTCPIPClientList()
-	if err != nil {
-		return err
+func (n *NasneCollector) collectNasne(ctx context.Context, ip string, cs []namedCollector) error {
+	ctx, span := tracer.Start(ctx, "collect.box", trace.WithAttributes(
+		attribute.String("net.peer.name", ip),
+		attribute.String("nasne.box", ip),
+	))
+
+	snap := &Snapshot{Addr: ip}
+	name, err := n.collectNasneOnce(ctx, ip, cs, snap)
+
+	if name != "" {
+		span.SetAttributes(attribute.String("nasne.name", name))
 	}
+	endSpan(span, err)
 
-	n.dtcpipClientsGauge.With(commonLabel).Set(float64(dtcpipClientList.Number))
+	// A canceled collection tells nothing about the nasne.
+	if ctx.Err() == nil {
+		snap.Name = name
+		snap.Reachable = name != ""
+		snap.Time = time.Now()
+		if err != nil {
+			snap.Error = err.Error()
+		}
 
-	return nil
-}
+		n.status.set(BoxStatus{
+			Addr:            ip,
+			Name:            name,
+			Reachable:       snap.Reachable,
+			LastCollectTime: snap.Time,
+			LastError:       snap.Error,
+		})
 
-func (n *NasneCollector) collectRecordings(client *nasneclient.NasneClient, commonLabel prometheus.Labels) error {
-	boxStatusList, err := client.GetBoxStatusList()
-	if err != nil {
-		return err
+		for _, h := range n.snapshotHooks {
+			h(ctx, snap)
+		}
 	}
 
-	var recordTotal float64
-	if boxStatusList.TuningStatus.Status == 3 {
-		recordTotal = 1
-	}
+	return err
+}
 
-	n.recordingsGauge.With(commonLabel).Set(recordTotal)
+// collectNasneOnce collects the metrics of the nasne at ip with cs, and records
+// the responses to snap. It returns the name of the nasne if the nasne is
+// reachable.
+func (n *NasneCollector) collectNasneOnce(ctx context.Context, ip string, cs []namedCollector, snap *Snapshot) (string, error) {
+	requestID := newRequestID()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("nasne.request_id", requestID))
 
-	return nil
-}
+	logger := n.logger.With("box", ip, "request_id", requestID)
+
+	logger.Debug("start collect")
+	start := time.Now()
 
-func (n *NasneCollector) collectRecorded(client *nasneclient.NasneClient, commonLabel prometheus.Labels) error {
-	recordedTitleList, err := client.GetRecordedTitleList()
+	client, err := nasneclient.NewNasneClient(ip, n.logger.With("request_id", requestID))
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to collect %v (request_id = %v): %v", ip, requestID, err)
 	}
+	client.HTTPClient = n.httpClient
+	client.Cache = n.cache
+	client.Limiter = n.limiter
 
-	n.recordedTitlesGauge.With(commonLabel).Set(float64(recordedTitleList.TotalMatches))
-
-	return nil
-}
-
-func (n *NasneCollector) collectReserved(client *nasneclient.NasneClient, commonLabel prometheus.Labels) error {
-	reservedList, err := client.GetReservedList()
+	commonLabel, err := n.getCommonLabel(ctx, client)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to collect %v (request_id = %v): %v", ip, requestID, err)
 	}
 
-	var conflictCount float64
-	var notFoundCount float64
-	for _, r := range reservedList.Item {
-		if r.EventID == nasneclient.EventIDNotFound {
-			notFoundCount++
-			continue
-		}
-		if r.ConflictID == nasneclient.ConflictIDConflictNG {
-			conflictCount++
-			continue
+	var lastErr error
+	for _, c := range cs {
+		if err := n.update(ctx, c, client, commonLabel, snap); err != nil {
+			logger.Error("collector failed", "collector", c.name, "err", err)
+			lastErr = err
 		}
 	}
 
-	n.reservedConflictTitlesGauge.With(commonLabel).Set(conflictCount)
-	n.reservedNotFoundTitlesGauge.With(commonLabel).Set(notFoundCount)
-	n.reservedTitlesGauge.With(commonLabel).Set(float64(reservedList.TotalMatches))
+	end := time.Now()
+	if err := n.collectCollectionDuration(start, end, commonLabel, ip, requestID); err != nil {
+		logger.Error("failed to observe collect duration", "err", err)
+	}
 
-	return nil
-}
+	logger.Debug("end collect", "name", commonLabel[labelName], "duration", end.Sub(start))
 
-func (n *NasneCollector) getCommonLabel(client *nasneclient.NasneClient) (prometheus.Labels, error) {
-	bn, err := client.GetBoxName()
-	if err != nil {
-		return nil, err
+	if lastErr != nil {
+		return commonLabel[labelName], fmt.Errorf("failed to collect %v (request_id = %v): %v", ip, requestID, lastErr)
 	}
 
-	return prometheus.Labels{
-		labelName: bn.Name,
-	}, nil
+	return commonLabel[labelName], nil
 }
 
-func (n *NasneCollector) runCollect() {
-	glog.V(2).Info("start collect")
-
-	for _, ip := range n.nasneAddrs {
-		glog.V(2).Infof("start colllect: ipaddr = %v", ip)
-		start := time.Now()
-
-		client, err := nasneclient.NewNasneClient(ip)
-		if err != nil {
-			glog.Error(err)
-			continue
-		}
-
-		commonLabel, err := n.getCommonLabel(client)
-		if err != nil {
-			glog.Error(err)
-			continue
-		}
+// update runs the collector c and records its duration and result.
+func (n *NasneCollector) update(ctx context.Context, c namedCollector, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	ctx, span := tracer.Start(ctx, "collector "+c.name, trace.WithAttributes(
+		attribute.String("nasne.collector", c.name),
+	))
 
-		if err := n.collectInfo(client, commonLabel); err != nil {
-			glog.Error(err)
-		}
+	start := time.Now()
+	err := c.collector.Update(ctx, client, commonLabel, snap)
+	duration := time.Since(start)
 
-		if err := n.collectHDD(client, commonLabel); err != nil {
-			glog.Error(err)
-		}
+	endSpan(span, err)
 
-		if err := n.collectDTCPClient(client, commonLabel); err != nil {
-			glog.Error(err)
-		}
-
-		if err := n.collectRecordings(client, commonLabel); err != nil {
-			glog.Error(err)
-		}
+	labels := mergeLabels(commonLabel, prometheus.Labels{labelCollector: c.name})
 
-		if err := n.collectRecorded(client, commonLabel); err != nil {
-			glog.Error(err)
-		}
+	var success float64
+	if err == nil {
+		success = 1
+	}
+	n.collectorDurationSecondsGauge.With(labels).Set(duration.Seconds())
+	n.collectorSuccessGauge.With(labels).Set(success)
 
-		if err := n.collectReserved(client, commonLabel); err != nil {
-			glog.Error(err)
-		}
+	n.logger.Debug("collector done", "name", commonLabel[labelName], "collector", c.name, "duration", duration, "err", err)
 
-		if err := n.collectCollectionDuration(start, time.Now(), commonLabel); err != nil {
-			glog.Error(err)
-		}
+	if err != nil {
+		return fmt.Errorf("collector %v failed: %v", c.name, err)
+	}
+	return nil
+}
 
-		glog.V(2).Infof("end colllect: ipaddr = %v", ip)
+// endSpan ends span with the status of err.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
+}
 
-	glog.V(2).Info("end collect")
+// newRequestID returns a random ID to identify a collection of a nasne.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 func mergeLabels(l1, l2 prometheus.Labels) prometheus.Labels {
@@ -393,3 +401,26 @@ func mergeLabels(l1, l2 prometheus.Labels) prometheus.Labels {
 
 	return l
 }
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// truncateRunes returns the first n runes of s.
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectCollectionDuration(t *testing.T) {
+	const requestID = "0123456789abcdef"
+
+	tests := []struct {
+		name         string
+		addr         string
+		boxName      string
+		wantExemplar bool
+		wantName     string
+	}{
+		{
+			name:         "short",
+			addr:         "192.0.2.1",
+			boxName:      "nasne1",
+			wantExemplar: true,
+			wantName:     "nasne1",
+		},
+		{
+			name:         "long name",
+			addr:         "192.0.2.1",
+			boxName:      strings.Repeat("な", 200),
+			wantExemplar: true,
+			// 128 - len("addr") - 9 - len("request_id") - 16 - len("name")
+			wantName: strings.Repeat("な", 85),
+		},
+		{
+			// The addr of 96 runes leaves no rune for the name.
+			name:         "long addr",
+			addr:         strings.Repeat("a", 84) + ".example.com",
+			boxName:      "nasne1",
+			wantExemplar: true,
+		},
+		{
+			// The address alone exceeds the limit.
+			name:    "too long addr",
+			addr:    strings.Repeat("a", 200) + ".example.com",
+			boxName: "nasne1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewNasneCollector(slog.New(slog.DiscardHandler), []string{tt.addr}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			labels := prometheus.Labels{labelName: tt.boxName}
+			if err := n.collectCollectionDuration(start, start.Add(time.Second), labels, tt.addr, requestID); err != nil {
+				t.Fatal(err)
+			}
+
+			m := &dto.Metric{}
+			if err := n.collectDurationSecondsHistogram.With(labels).(prometheus.Metric).Write(m); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.GetHistogram().GetSampleCount(); got != 1 {
+				t.Fatalf("sample count = %d, want 1", got)
+			}
+
+			var exemplar *dto.Exemplar
+			for _, b := range m.GetHistogram().GetBucket() {
+				if b.GetExemplar() != nil {
+					exemplar = b.GetExemplar()
+				}
+			}
+			if (exemplar != nil) != tt.wantExemplar {
+				t.Fatalf("exemplar = %v, want %v", exemplar, tt.wantExemplar)
+			}
+			if exemplar == nil {
+				return
+			}
+
+			got := map[string]string{}
+			var runes int
+			for _, lp := range exemplar.GetLabel() {
+				got[lp.GetName()] = lp.GetValue()
+				runes += utf8.RuneCountInString(lp.GetName()) + utf8.RuneCountInString(lp.GetValue())
+			}
+			if runes > prometheus.ExemplarMaxRunes {
+				t.Errorf("exemplar has %d runes, want up to %d", runes, prometheus.ExemplarMaxRunes)
+			}
+			if got[labelAddr] != tt.addr || got[labelRequestID] != requestID {
+				t.Errorf("exemplar = %v, want the addr and request_id", got)
+			}
+			if got[labelName] != tt.wantName {
+				t.Errorf("name in exemplar = %q, want %q", got[labelName], tt.wantName)
+			}
+		})
+	}
+}
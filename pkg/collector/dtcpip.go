@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("dtcpip", defaultEnabled, newDTCPIPCollector)
+}
+
+type dtcpipCollector struct {
+	dtcpipClientsGauge *prometheus.GaugeVec
+}
+
+func newDTCPIPCollector() Collector {
+	return &dtcpipCollector{
+		dtcpipClientsGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "dtcpip_clients",
+				Help:      "Number of clients connected with DTCP-IP.",
+			},
+			[]string{
+				labelName,
+			},
+		),
+	}
+}
+
+func (c *dtcpipCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.dtcpipClientsGauge}
+}
+
+func (c *dtcpipCollector) Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	dtcpipClientList, err := client.GetDTCPIPClientList(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.dtcpipClientsGauge.With(commonLabel).Set(float64(dtcpipClientList.Number))
+	snap.DTCPIPClients = dtcpipClientList
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("hdd", defaultEnabled, newHDDCollector)
+}
+
+type hddCollector struct {
+	growth *hddGrowth
+
+	hddInfoGauge       *prometheus.GaugeVec
+	hddSizeBytesGauge  *prometheus.GaugeVec
+	hddUsageBytesGauge *prometheus.GaugeVec
+	hddFreeBytesGauge  *prometheus.GaugeVec
+	hddMountedGauge    *prometheus.GaugeVec
+	hddRegisteredGauge *prometheus.GaugeVec
+	hddGrowthGauge     *prometheus.GaugeVec
+	hddTimeToFullGauge *prometheus.GaugeVec
+}
+
+func newHDDCollector() Collector {
+	hddLabels := []string{
+		labelName,
+		labelID,
+		labelFormat,
+		labelHDDName,
+		labelVendorID,
+		labelProductID,
+		labelInternal,
+	}
+
+	return &hddCollector{
+		growth: newHDDGrowth(defaultHDDGrowthWindow),
+
+		hddInfoGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_info",
+				Help:      "HDD information.",
+			},
+			append([]string{labelSerialNumber}, hddLabels...),
+		),
+		hddSizeBytesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_size_bytes",
+				Help:      "HDD size in bytes.",
+			},
+			hddLabels,
+		),
+		hddUsageBytesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_usage_bytes",
+				Help:      "HDD usage in bytes.",
+			},
+			hddLabels,
+		),
+		hddFreeBytesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_free_bytes",
+				Help:      "HDD free space in bytes.",
+			},
+			hddLabels,
+		),
+		hddMountedGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_mounted",
+				Help:      "Whether HDD is mounted.",
+			},
+			hddLabels,
+		),
+		hddRegisteredGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_registered",
+				Help:      "Whether HDD is registered to nasne.",
+			},
+			hddLabels,
+		),
+		hddGrowthGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_usage_growth_bytes_per_second",
+				Help:      "Growth rate of HDD usage estimated over the growth window.",
+			},
+			hddLabels,
+		),
+		hddTimeToFullGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "hdd_time_to_full_seconds",
+				Help:      "Estimated time until HDD is full. +Inf if the usage is not growing.",
+			},
+			hddLabels,
+		),
+	}
+}
+
+func (c *hddCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.hddInfoGauge,
+		c.hddSizeBytesGauge,
+		c.hddUsageBytesGauge,
+		c.hddFreeBytesGauge,
+		c.hddMountedGauge,
+		c.hddRegisteredGauge,
+		c.hddGrowthGauge,
+		c.hddTimeToFullGauge,
+	}
+}
+
+func (c *hddCollector) Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	hddList, err := client.GetHDDList(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A partial list would look like unmounted HDDs.
+	hdds := []*nasneclient.HDDInfoHDD{}
+	for _, hdd := range hddList.HDD {
+		hddInfo, err := client.GetHDDInfo(ctx, hdd.ID)
+		if err != nil {
+			return err
+		}
+		hdds = append(hdds, &hddInfo.HDD)
+	}
+
+	c.update(commonLabel, hdds, time.Now())
+
+	snap.HDD = hdds
+	return nil
+}
+
+// update sets the metrics of hdds listed at now.
+func (c *hddCollector) update(commonLabel prometheus.Labels, hdds []*nasneclient.HDDInfoHDD, now time.Time) {
+	// Remove the series of the HDDs which are no longer listed, such as an
+	// unplugged USB HDD or a replaced one.
+	for _, g := range []*prometheus.GaugeVec{
+		c.hddInfoGauge,
+		c.hddSizeBytesGauge,
+		c.hddUsageBytesGauge,
+		c.hddFreeBytesGauge,
+		c.hddMountedGauge,
+		c.hddRegisteredGauge,
+		c.hddGrowthGauge,
+		c.hddTimeToFullGauge,
+	} {
+		g.DeletePartialMatch(prometheus.Labels{labelName: commonLabel[labelName]})
+	}
+
+	keys := map[string]bool{}
+	for _, hdd := range hdds {
+		labels := mergeLabels(commonLabel, prometheus.Labels{
+			labelID:        strconv.Itoa(hdd.ID),
+			labelFormat:    hdd.Format,
+			labelHDDName:   hdd.Name,
+			labelVendorID:  hdd.VendorID,
+			labelProductID: hdd.ProductID,
+			labelInternal:  strconv.FormatBool(hdd.InternalFlag == nasneclient.InternalFlagInternal),
+		})
+
+		c.hddInfoGauge.With(mergeLabels(labels, prometheus.Labels{labelSerialNumber: hdd.SerialNumber})).Set(1)
+		c.hddSizeBytesGauge.With(labels).Set(hdd.TotalVolumeSize)
+		c.hddUsageBytesGauge.With(labels).Set(hdd.UsedVolumeSize)
+		c.hddFreeBytesGauge.With(labels).Set(hdd.FreeVolumeSize)
+		c.hddMountedGauge.With(labels).Set(boolToFloat(hdd.MountStatus == nasneclient.MountStatusMounted))
+		c.hddRegisteredGauge.With(labels).Set(boolToFloat(hdd.RegisterFlag == nasneclient.RegisterFlagRegistered))
+
+		// The serial number separates the samples of a replaced HDD.
+		key := commonLabel[labelName] + "/" + strconv.Itoa(hdd.ID) + "/" + hdd.SerialNumber
+		keys[key] = true
+		if rate, ok := c.growth.add(key, now, hdd.UsedVolumeSize); ok {
+			c.hddGrowthGauge.With(labels).Set(rate)
+			c.hddTimeToFullGauge.With(labels).Set(timeToFull(hdd.FreeVolumeSize, rate))
+		}
+	}
+
+	c.growth.retain(commonLabel[labelName]+"/", keys)
+}
+
+// timeToFull returns the seconds until free bytes are used up at rate bytes
+// per second, or +Inf if the usage is not growing.
+func timeToFull(free, rate float64) float64 {
+	if rate <= 0 {
+		return math.Inf(1)
+	}
+	return free / rate
+}
+
+func (c *hddCollector) saveState() (json.RawMessage, error) {
+	return c.growth.saveState()
+}
+
+func (c *hddCollector) loadState(data json.RawMessage) error {
+	return c.growth.loadState(data)
+}
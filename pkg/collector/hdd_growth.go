@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHDDGrowthWindow is the default length of the window to estimate the
+// growth rate of HDD usage.
+const defaultHDDGrowthWindow = 24 * time.Hour
+
+type hddSample struct {
+	Time time.Time `json:"time"`
+	Used float64   `json:"used"`
+}
+
+// hddGrowth keeps the recent samples of HDD usage to estimate its growth rate.
+type hddGrowth struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	samples map[string][]hddSample
+}
+
+func newHDDGrowth(window time.Duration) *hddGrowth {
+	return &hddGrowth{
+		window:  window,
+		samples: map[string][]hddSample{},
+	}
+}
+
+// add adds a sample of the HDD identified by key and returns the growth rate
+// in bytes per second over the window. ok is false until the window has
+// enough samples.
+func (g *hddGrowth) add(key string, t time.Time, used float64) (rate float64, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	samples := append(g.samples[key], hddSample{Time: t, Used: used})
+
+	var i int
+	for i < len(samples) && t.Sub(samples[i].Time) > g.window {
+		i++
+	}
+	samples = samples[i:]
+	g.samples[key] = samples
+
+	return linearRegression(samples)
+}
+
+// retain removes the samples of the HDDs whose keys have prefix and are not in
+// keys, so that the windows of removed HDDs do not stay forever.
+func (g *hddGrowth) retain(prefix string, keys map[string]bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key := range g.samples {
+		if strings.HasPrefix(key, prefix) && !keys[key] {
+			delete(g.samples, key)
+		}
+	}
+}
+
+// linearRegression returns the slope of the least squares line of samples in
+// bytes per second. ok is false if the slope is undefined.
+func linearRegression(samples []hddSample) (slope float64, ok bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+
+	// The times are relative to the first sample to keep the precision.
+	origin := samples[0].Time
+
+	n := float64(len(samples))
+	var sumX, sumY float64
+	for _, s := range samples {
+		sumX += s.Time.Sub(origin).Seconds()
+		sumY += s.Used
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxx, sxy float64
+	for _, s := range samples {
+		dx := s.Time.Sub(origin).Seconds() - meanX
+		sxx += dx * dx
+		sxy += dx * (s.Used - meanY)
+	}
+	if sxx == 0 {
+		return 0, false
+	}
+
+	return sxy / sxx, true
+}
+
+func (g *hddGrowth) saveState() (json.RawMessage, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return json.Marshal(g.samples)
+}
+
+func (g *hddGrowth) loadState(data json.RawMessage) error {
+	samples := map[string][]hddSample{}
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.samples = samples
+	return nil
+}
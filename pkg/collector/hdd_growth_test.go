@@ -0,0 +1,264 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sampleOrigin = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// samplesOf returns samples taken every interval starting at sampleOrigin.
+func samplesOf(interval time.Duration, used ...float64) []hddSample {
+	var samples []hddSample
+	for i, u := range used {
+		samples = append(samples, hddSample{Time: sampleOrigin.Add(time.Duration(i) * interval), Used: u})
+	}
+	return samples
+}
+
+func TestLinearRegression(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []hddSample
+		wantSlope float64
+		wantOK    bool
+	}{
+		{
+			name:    "zero samples",
+			samples: nil,
+		},
+		{
+			name:    "one sample",
+			samples: samplesOf(time.Minute, 100),
+		},
+		{
+			name:      "two samples",
+			samples:   samplesOf(time.Minute, 100, 700),
+			wantSlope: 10,
+			wantOK:    true,
+		},
+		{
+			name:      "N samples on a line",
+			samples:   samplesOf(10*time.Second, 0, 50, 100, 150, 200),
+			wantSlope: 5,
+			wantOK:    true,
+		},
+		{
+			// The least squares line of (0,0) (1,1) (2,0) (3,3) is y = 0.8x - 0.2.
+			name:      "N noisy samples",
+			samples:   samplesOf(time.Second, 0, 1, 0, 3),
+			wantSlope: 0.8,
+			wantOK:    true,
+		},
+		{
+			name:      "flat series",
+			samples:   samplesOf(time.Minute, 500, 500, 500),
+			wantSlope: 0,
+			wantOK:    true,
+		},
+		{
+			name:      "shrinking series",
+			samples:   samplesOf(time.Second, 300, 200, 100),
+			wantSlope: -100,
+			wantOK:    true,
+		},
+		{
+			name:    "samples at the same time",
+			samples: samplesOf(0, 100, 200),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slope, ok := linearRegression(tt.samples)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(slope-tt.wantSlope) > 1e-9 {
+				t.Errorf("slope = %v, want %v", slope, tt.wantSlope)
+			}
+		})
+	}
+}
+
+func TestHDDGrowthAdd(t *testing.T) {
+	type add struct {
+		after time.Duration
+		used  float64
+	}
+
+	tests := []struct {
+		name      string
+		window    time.Duration
+		adds      []add
+		wantRate  float64
+		wantOK    bool
+		wantCount int
+	}{
+		{
+			name:      "first sample",
+			window:    time.Hour,
+			adds:      []add{{0, 100}},
+			wantCount: 1,
+		},
+		{
+			name:      "samples in the window",
+			window:    time.Hour,
+			adds:      []add{{0, 100}, {10 * time.Second, 200}, {20 * time.Second, 300}},
+			wantRate:  10,
+			wantOK:    true,
+			wantCount: 3,
+		},
+		{
+			// The samples at 0s and 10s are evicted, so the early burst does
+			// not affect the rate.
+			name:      "window eviction",
+			window:    time.Minute,
+			adds:      []add{{0, 0}, {10 * time.Second, 10000}, {80 * time.Second, 10000}, {100 * time.Second, 10200}},
+			wantRate:  10,
+			wantOK:    true,
+			wantCount: 2,
+		},
+		{
+			name:      "all but the last sample evicted",
+			window:    time.Minute,
+			adds:      []add{{0, 100}, {10 * time.Second, 200}, {time.Hour, 300}},
+			wantCount: 1,
+		},
+		{
+			name:      "sample on the window boundary is kept",
+			window:    time.Minute,
+			adds:      []add{{0, 0}, {time.Minute, 60}},
+			wantRate:  1,
+			wantOK:    true,
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newHDDGrowth(tt.window)
+
+			var rate float64
+			var ok bool
+			for _, a := range tt.adds {
+				rate, ok = g.add("nasne1/0/S0", sampleOrigin.Add(a.after), a.used)
+			}
+
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(rate-tt.wantRate) > 1e-9 {
+				t.Errorf("rate = %v, want %v", rate, tt.wantRate)
+			}
+			if got := len(g.samples["nasne1/0/S0"]); got != tt.wantCount {
+				t.Errorf("kept %d samples, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestHDDGrowthKeys(t *testing.T) {
+	g := newHDDGrowth(time.Hour)
+
+	g.add("nasne1/0/S0", sampleOrigin, 100)
+	g.add("nasne1/0/S1", sampleOrigin.Add(time.Second), 5000)
+
+	// A replaced HDD has a different key, so its samples are not mixed.
+	if _, ok := g.add("nasne1/0/S1", sampleOrigin.Add(2*time.Second), 5000); !ok {
+		t.Fatal("ok = false, want true")
+	}
+	if rate, _ := g.add("nasne1/0/S0", sampleOrigin.Add(10*time.Second), 200); rate != 10 {
+		t.Errorf("rate = %v, want 10", rate)
+	}
+}
+
+func TestHDDGrowthRetain(t *testing.T) {
+	g := newHDDGrowth(time.Hour)
+	for _, key := range []string{"nasne1/0/S0", "nasne1/1/S1", "nasne2/1/S1"} {
+		g.add(key, sampleOrigin, 100)
+	}
+
+	g.retain("nasne1/", map[string]bool{"nasne1/0/S0": true})
+
+	if _, ok := g.samples["nasne1/1/S1"]; ok {
+		t.Error("samples of nasne1/1/S1 are kept")
+	}
+	// The samples of the other nasne are kept.
+	for _, key := range []string{"nasne1/0/S0", "nasne2/1/S1"} {
+		if _, ok := g.samples[key]; !ok {
+			t.Errorf("samples of %v are removed", key)
+		}
+	}
+}
+
+func TestHDDCollectorRemovedHDD(t *testing.T) {
+	c := newHDDCollector().(*hddCollector)
+	commonLabel := prometheus.Labels{labelName: "nasne1"}
+	internal := &nasneclient.HDDInfoHDD{ID: 0, SerialNumber: "S0", UsedVolumeSize: 100, FreeVolumeSize: 1000}
+	usb := &nasneclient.HDDInfoHDD{ID: 1, SerialNumber: "S1", UsedVolumeSize: 100, FreeVolumeSize: 1000}
+
+	c.update(commonLabel, []*nasneclient.HDDInfoHDD{internal, usb}, sampleOrigin)
+	c.update(commonLabel, []*nasneclient.HDDInfoHDD{internal, usb}, sampleOrigin.Add(time.Minute))
+	if got := testCount(t, c.hddTimeToFullGauge); got != 2 {
+		t.Fatalf("time to full of %d HDDs, want 2", got)
+	}
+
+	// The USB HDD is unplugged.
+	c.update(commonLabel, []*nasneclient.HDDInfoHDD{internal}, sampleOrigin.Add(2*time.Minute))
+
+	for name, g := range map[string]*prometheus.GaugeVec{"growth": c.hddGrowthGauge, "time to full": c.hddTimeToFullGauge} {
+		if got := testCount(t, g); got != 1 {
+			t.Errorf("%v of %d HDDs, want 1", name, got)
+		}
+	}
+	if _, ok := c.growth.samples["nasne1/1/S1"]; ok {
+		t.Error("samples of the unplugged HDD are kept")
+	}
+	if _, ok := c.growth.samples["nasne1/0/S0"]; !ok {
+		t.Error("samples of the listed HDD are removed")
+	}
+}
+
+// testCount returns the number of series in c.
+func testCount(t *testing.T, c prometheus.Collector) int {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var n int
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func TestTimeToFull(t *testing.T) {
+	tests := []struct {
+		name string
+		free float64
+		rate float64
+		want float64
+	}{
+		{name: "growing", free: 1000, rate: 10, want: 100},
+		{name: "flat", free: 1000, rate: 0, want: math.Inf(1)},
+		{name: "shrinking", free: 1000, rate: -10, want: math.Inf(1)},
+		{name: "full", free: 0, rate: 10, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timeToFull(tt.free, tt.rate); got != tt.want {
+				t.Errorf("timeToFull(%v, %v) = %v, want %v", tt.free, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BoxStatus is the status of the last collection of a nasne.
+type BoxStatus struct {
+	Addr            string    `json:"addr"`
+	Name            string    `json:"name,omitempty"`
+	Reachable       bool      `json:"reachable"`
+	LastCollectTime time.Time `json:"last_collect_time"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// Status is the status of the collector.
+type Status struct {
+	// FirstCollectionDone is true if every nasne has been collected at least once.
+	FirstCollectionDone bool        `json:"first_collection_done"`
+	Reachable           int         `json:"reachable"`
+	Boxes               []BoxStatus `json:"boxes"`
+}
+
+type statusStore struct {
+	mu    sync.Mutex
+	boxes map[string]BoxStatus
+}
+
+func (s *statusStore) set(b BoxStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.boxes == nil {
+		s.boxes = map[string]BoxStatus{}
+	}
+	s.boxes[b.Addr] = b
+}
+
+// Status returns the status of the last collection of each nasne.
+func (n *NasneCollector) Status() Status {
+	n.status.mu.Lock()
+	defer n.status.mu.Unlock()
+
+	s := Status{FirstCollectionDone: true}
+	for _, addr := range n.nasneAddrs {
+		b, ok := n.status.boxes[addr]
+		if !ok {
+			s.FirstCollectionDone = false
+			b = BoxStatus{Addr: addr}
+		}
+		if b.Reachable {
+			s.Reachable++
+		}
+		s.Boxes = append(s.Boxes, b)
+	}
+
+	return s
+}
+
+// HealthyHandler returns a handler which always responds OK while the process is alive.
+func HealthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+	})
+}
+
+// ReadyHandler returns a handler which responds OK after the first collection
+// is done and at least minReachable nasnes are reachable, and
+// ServiceUnavailable otherwise. minReachable is capped at the number of nasnes.
+func (n *NasneCollector) ReadyHandler(minReachable int) http.Handler {
+	if minReachable > len(n.nasneAddrs) {
+		minReachable = len(n.nasneAddrs)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := n.Status()
+
+		ready := s.FirstCollectionDone && s.Reachable >= minReachable
+
+		status := "ready"
+		code := http.StatusOK
+		if !ready {
+			status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, code, readyResponse{
+			Status:              status,
+			FirstCollectionDone: s.FirstCollectionDone,
+			Reachable:           s.Reachable,
+			MinReachable:        minReachable,
+			Boxes:               s.Boxes,
+		})
+	})
+}
+
+type readyResponse struct {
+	Status              string      `json:"status"`
+	FirstCollectionDone bool        `json:"first_collection_done"`
+	Reachable           int         `json:"reachable"`
+	MinReachable        int         `json:"min_reachable"`
+	Boxes               []BoxStatus `json:"boxes"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("info", defaultEnabled, newInfoCollector)
+}
+
+type infoCollector struct {
+	infoGauge *prometheus.GaugeVec
+}
+
+func newInfoCollector() Collector {
+	return &infoCollector{
+		infoGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "info",
+				Help:      "Information of nasne.",
+			},
+			[]string{
+				labelName,
+				labelSoftwareVersion,
+				labelHardwareVersion,
+				labelProductName,
+			},
+		),
+	}
+}
+
+func (c *infoCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.infoGauge}
+}
+
+func (c *infoCollector) Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	softwareVersion, err := client.GetSoftwareVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	hardwareVersion, err := client.GetHardwareVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	labels := prometheus.Labels{
+		labelSoftwareVersion: softwareVersion.SoftwareVersion,
+		labelHardwareVersion: strconv.Itoa(hardwareVersion.HardwareVersion),
+		labelProductName:     hardwareVersion.ProductName,
+	}
+
+	c.infoGauge.With(mergeLabels(commonLabel, labels)).Set(1)
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("recorded", defaultEnabled, newRecordedCollector)
+}
+
+type recordedCollector struct {
+	recordedTitlesGauge         *prometheus.GaugeVec
+	recordingsCompletedCounter  *prometheus.CounterVec
+	recordingsDeletedCounter    *prometheus.CounterVec
+	recordedSecondsAddedCounter *prometheus.CounterVec
+
+	mu sync.Mutex
+	// snapshots holds the last recorded titles of each nasne by ID.
+	snapshots map[string]map[string]*nasneclient.RecordedTitleListItem
+}
+
+func newRecordedCollector() Collector {
+	return &recordedCollector{
+		recordedTitlesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "recorded_titles",
+				Help:      "Number of recorded titles.",
+			},
+			[]string{
+				labelName,
+			},
+		),
+		recordingsCompletedCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "recordings_completed_total",
+				Help:      "Number of recorded titles added. Persisted across restarts if the state directory is set.",
+			},
+			[]string{
+				labelName,
+				labelBroadcastingType,
+			},
+		),
+		recordingsDeletedCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "recordings_deleted_total",
+				Help:      "Number of recorded titles deleted. Persisted across restarts if the state directory is set.",
+			},
+			[]string{
+				labelName,
+				labelBroadcastingType,
+			},
+		),
+		recordedSecondsAddedCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "recorded_seconds_added_total",
+				Help:      "Total duration of recorded titles added in seconds. Persisted across restarts if the state directory is set.",
+			},
+			[]string{
+				labelName,
+				labelBroadcastingType,
+			},
+		),
+		snapshots: map[string]map[string]*nasneclient.RecordedTitleListItem{},
+	}
+}
+
+func (c *recordedCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.recordedTitlesGauge,
+		c.recordingsCompletedCounter,
+		c.recordingsDeletedCounter,
+		c.recordedSecondsAddedCounter,
+	}
+}
+
+func (c *recordedCollector) Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	recordedTitleList, err := client.GetRecordedTitleList(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.recordedTitlesGauge.With(commonLabel).Set(float64(recordedTitleList.TotalMatches))
+	snap.RecordedTitles = recordedTitleList
+
+	// A partial list would look like deletions.
+	if len(recordedTitleList.Item) < recordedTitleList.TotalMatches {
+		return nil
+	}
+
+	c.diff(commonLabel, recordedTitleList.Item)
+
+	return nil
+}
+
+// diff counts the titles added and deleted since the last snapshot of the
+// nasne, and replaces the snapshot with items. The first snapshot is not
+// counted.
+func (c *recordedCollector) diff(commonLabel prometheus.Labels, items []*nasneclient.RecordedTitleListItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := commonLabel[labelName]
+
+	snapshot := map[string]*nasneclient.RecordedTitleListItem{}
+	for _, item := range items {
+		snapshot[item.ID] = item
+	}
+
+	last, ok := c.snapshots[name]
+	c.snapshots[name] = snapshot
+	if !ok {
+		return
+	}
+
+	for id, item := range snapshot {
+		if _, ok := last[id]; ok {
+			continue
+		}
+		labels := mergeLabels(commonLabel, prometheus.Labels{labelBroadcastingType: item.BroadcastingType.String()})
+		c.recordingsCompletedCounter.With(labels).Inc()
+		c.recordedSecondsAddedCounter.With(labels).Add(item.Duration.Seconds())
+	}
+
+	for id, item := range last {
+		if _, ok := snapshot[id]; ok {
+			continue
+		}
+		labels := mergeLabels(commonLabel, prometheus.Labels{labelBroadcastingType: item.BroadcastingType.String()})
+		c.recordingsDeletedCounter.With(labels).Inc()
+	}
+}
+
+type recordedState struct {
+	Snapshots    map[string]map[string]*nasneclient.RecordedTitleListItem `json:"snapshots"`
+	Completed    []counterState                                           `json:"completed"`
+	Deleted      []counterState                                           `json:"deleted"`
+	SecondsAdded []counterState                                           `json:"seconds_added"`
+}
+
+func (c *recordedCollector) saveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &recordedState{Snapshots: c.snapshots}
+
+	var err error
+	if s.Completed, err = saveCounterVec(c.recordingsCompletedCounter); err != nil {
+		return nil, err
+	}
+	if s.Deleted, err = saveCounterVec(c.recordingsDeletedCounter); err != nil {
+		return nil, err
+	}
+	if s.SecondsAdded, err = saveCounterVec(c.recordedSecondsAddedCounter); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(s)
+}
+
+func (c *recordedCollector) loadState(data json.RawMessage) error {
+	s := &recordedState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return err
+	}
+
+	// All counters are validated before any of them is restored, so that
+	// they are restored together or not at all.
+	loadCompleted, err := loadCounterVec(c.recordingsCompletedCounter, s.Completed)
+	if err != nil {
+		return err
+	}
+	loadDeleted, err := loadCounterVec(c.recordingsDeletedCounter, s.Deleted)
+	if err != nil {
+		return err
+	}
+	loadSecondsAdded, err := loadCounterVec(c.recordedSecondsAddedCounter, s.SecondsAdded)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loadCompleted()
+	loadDeleted()
+	loadSecondsAdded()
+
+	if s.Snapshots != nil {
+		c.snapshots = s.Snapshots
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultEnabled = true
+
+// Collector collects a part of the metrics of nasne.
+type Collector interface {
+	// Collectors returns the metrics of the collector to be registered.
+	Collectors() []prometheus.Collector
+	// Update collects the metrics from client. commonLabel holds the labels
+	// common to all metrics of the nasne. The responses of nasne are recorded
+	// to snap.
+	Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error
+}
+
+type factory struct {
+	isDefaultEnabled bool
+	new              func() Collector
+}
+
+var factories = map[string]factory{}
+
+func registerCollector(name string, isDefaultEnabled bool, new func() Collector) {
+	factories[name] = factory{
+		isDefaultEnabled: isDefaultEnabled,
+		new:              new,
+	}
+}
+
+// CollectorNames returns the sorted names of all collectors.
+func CollectorNames() []string {
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// IsDefaultEnabled returns whether the collector is enabled by default.
+func IsDefaultEnabled(name string) bool {
+	return factories[name].isDefaultEnabled
+}
+
+type namedCollector struct {
+	name      string
+	collector Collector
+}
+
+func newCollectors(names []string) ([]namedCollector, error) {
+	var cs []namedCollector
+	for _, name := range names {
+		f, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector: %v", name)
+		}
+		cs = append(cs, namedCollector{name: name, collector: f.new()})
+	}
+
+	return cs, nil
+}
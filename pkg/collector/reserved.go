@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("reserved", defaultEnabled, newReservedCollector)
+}
+
+type reservedCollector struct {
+	reservedTitlesGauge         *prometheus.GaugeVec
+	reservedConflictTitlesGauge *prometheus.GaugeVec
+	reservedNotFoundTitlesGauge *prometheus.GaugeVec
+}
+
+func newReservedCollector() Collector {
+	return &reservedCollector{
+		reservedTitlesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "reserved_titles",
+				Help:      "Number of reserved titles.",
+			},
+			[]string{
+				labelName,
+			},
+		),
+		reservedConflictTitlesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "reserved_conflict_titles",
+				Help:      "Number of Conflicting titles.",
+			},
+			[]string{
+				labelName,
+			},
+		),
+		reservedNotFoundTitlesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "reserved_notfound_titles",
+				Help:      "Number of titles that could not be found.",
+			},
+			[]string{
+				labelName,
+			},
+		),
+	}
+}
+
+func (c *reservedCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.reservedTitlesGauge,
+		c.reservedConflictTitlesGauge,
+		c.reservedNotFoundTitlesGauge,
+	}
+}
+
+func (c *reservedCollector) Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	reservedList, err := client.GetReservedList(ctx)
+	if err != nil {
+		return err
+	}
+
+	var conflictCount float64
+	var notFoundCount float64
+	for _, r := range reservedList.Item {
+		if r.EventID == nasneclient.EventIDNotFound {
+			notFoundCount++
+			continue
+		}
+		if r.ConflictID == nasneclient.ConflictIDConflictNG {
+			conflictCount++
+			continue
+		}
+	}
+
+	c.reservedConflictTitlesGauge.With(commonLabel).Set(conflictCount)
+	c.reservedNotFoundTitlesGauge.With(commonLabel).Set(notFoundCount)
+	c.reservedTitlesGauge.With(commonLabel).Set(float64(reservedList.TotalMatches))
+	snap.Reserved = reservedList
+
+	return nil
+}
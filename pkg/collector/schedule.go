@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Schedule is the schedule of collection.
+type Schedule struct {
+	// Interval is the default interval of collectors.
+	Interval time.Duration
+	// Intervals overrides Interval for each collector. The key is a collector
+	// name, or an address of nasne and a collector name joined with "/" such as
+	// "192.0.2.1/tuner". The latter takes precedence.
+	Intervals map[string]time.Duration
+	// Jitter is the maximum random delay added to each collection, so that
+	// collections of nasnes do not happen at the same time.
+	Jitter time.Duration
+}
+
+// SetSchedule sets the schedule used by Run.
+func (n *NasneCollector) SetSchedule(s Schedule) error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("interval must be positive: %v", s.Interval)
+	}
+	if s.Jitter < 0 {
+		return fmt.Errorf("jitter must not be negative: %v", s.Jitter)
+	}
+
+	for key, interval := range s.Intervals {
+		name := key
+		if i := strings.LastIndex(key, "/"); i >= 0 {
+			addr := key[:i]
+			if !n.hasAddr(addr) {
+				return fmt.Errorf("unknown nasne address: %v", addr)
+			}
+			name = key[i+1:]
+		}
+		if _, ok := factories[name]; !ok {
+			return fmt.Errorf("unknown collector: %v", name)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("interval of %v must be positive: %v", key, interval)
+		}
+	}
+
+	n.schedule = s
+	return nil
+}
+
+func (n *NasneCollector) hasAddr(addr string) bool {
+	for _, a := range n.nasneAddrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) interval(addr, name string) time.Duration {
+	if interval, ok := s.Intervals[addr+"/"+name]; ok {
+		return interval
+	}
+	if interval, ok := s.Intervals[name]; ok {
+		return interval
+	}
+	return s.Interval
+}
+
+func (s Schedule) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.Jitter)))
+}
+
+// job is a collector scheduled for a nasne.
+type job struct {
+	addr string
+	c    namedCollector
+	next time.Time
+}
+
+// Run collects metrics according to the schedule until ctx is canceled.
+// Each collector of each nasne is scheduled independently. Canceling ctx also
+// cancels the requests to nasne in flight. If the state file is set, the state
+// is checkpointed while running and saved when Run returns.
+func (n *NasneCollector) Run(ctx context.Context) error {
+	if n.statePath != "" {
+		done := make(chan struct{})
+		go n.runCheckpoint(done)
+		defer func() {
+			close(done)
+			if err := n.SaveState(); err != nil {
+				n.logger.Error("failed to save state", "path", n.statePath, "err", err)
+			}
+		}()
+	}
+
+	now := time.Now()
+
+	var jobs []*job
+	for _, addr := range n.nasneAddrs {
+		for _, c := range n.collectors {
+			j := &job{addr: addr, c: c, next: now.Add(n.schedule.jitter())}
+			n.setNextCollectTime(j)
+			jobs = append(jobs, j)
+		}
+	}
+
+	if len(jobs) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	for {
+		next := jobs[0].next
+		for _, j := range jobs {
+			if j.next.Before(next) {
+				next = j.next
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			n.logger.Debug("stop collector")
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		n.runJobs(ctx, jobs)
+	}
+}
+
+// runJobs runs the jobs which are due, and then the hooks.
+func (n *NasneCollector) runJobs(ctx context.Context, jobs []*job) {
+	now := time.Now()
+
+	var addrs []string
+	due := map[string][]namedCollector{}
+	for _, j := range jobs {
+		if j.next.After(now) {
+			continue
+		}
+
+		if _, ok := due[j.addr]; !ok {
+			addrs = append(addrs, j.addr)
+		}
+		due[j.addr] = append(due[j.addr], j.c)
+
+		j.next = now.Add(n.schedule.interval(j.addr, j.c.name) + n.schedule.jitter())
+		n.setNextCollectTime(j)
+	}
+
+	n.collectDue(ctx, addrs, due)
+
+	for _, h := range n.hooks {
+		if ctx.Err() != nil {
+			return
+		}
+		h(ctx)
+	}
+}
+
+// collectDue collects the due collectors of addrs in a trace.
+func (n *NasneCollector) collectDue(ctx context.Context, addrs []string, due map[string][]namedCollector) {
+	ctx, span := tracer.Start(ctx, "collect", trace.WithNewRoot())
+	defer span.End()
+
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := n.collectNasne(ctx, addr, due[addr]); err != nil {
+			n.logger.Error("collect failed", "box", addr, "err", err)
+		}
+	}
+}
+
+func (n *NasneCollector) setNextCollectTime(j *job) {
+	labels := prometheus.Labels{
+		labelAddr:      j.addr,
+		labelCollector: j.c.name,
+	}
+	n.nextCollectTimeGauge.With(labels).Set(float64(j.next.UnixNano()) / 1e9)
+}
@@ -0,0 +1,33 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+// Snapshot is the state of a nasne observed by a collection. The responses
+// are nil if their collector did not run or failed in the collection.
+// Snapshots must not be modified by hooks.
+type Snapshot struct {
+	Addr string
+	Name string
+	Time time.Time
+	// Reachable is whether the name of the nasne could be got.
+	Reachable bool
+	// Error is the error of the collection if any.
+	Error string
+
+	BoxStatus      *nasneclient.BoxStatusList
+	HDD            []*nasneclient.HDDInfoHDD
+	DTCPIPClients  *nasneclient.DTCPIPClientList
+	RecordedTitles *nasneclient.RecordedTitleList
+	Reserved       *nasneclient.ReservedList
+}
+
+// AddSnapshotHook adds a function which is called with the snapshot of each
+// nasne after it is collected. Hooks are not called for canceled collections.
+func (n *NasneCollector) AddSnapshotHook(h func(ctx context.Context, s *Snapshot)) {
+	n.snapshotHooks = append(n.snapshotHooks, h)
+}
@@ -0,0 +1,268 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// stateVersion is the version of the schema of the state file. It must be
+// incremented on incompatible changes, and the state of another version is
+// discarded.
+const stateVersion = 1
+
+// statefulCollector is a Collector which keeps state across collections, such
+// as the counters derived from the diffs of snapshots.
+type statefulCollector interface {
+	Collector
+	saveState() (json.RawMessage, error)
+	loadState(data json.RawMessage) error
+}
+
+// State is the state of a component other than the collectors, such as the
+// event detector, which is saved to the state file with the collectors.
+type State interface {
+	SaveState() (json.RawMessage, error)
+	LoadState(data json.RawMessage) error
+}
+
+type namedState struct {
+	name  string
+	state State
+}
+
+type stateFile struct {
+	Version    int                        `json:"version"`
+	SavedAt    time.Time                  `json:"saved_at"`
+	Collectors map[string]json.RawMessage `json:"collectors"`
+	States     map[string]json.RawMessage `json:"states,omitempty"`
+}
+
+// SetStateFile sets the file to checkpoint the state of collectors to. Run
+// saves the state every interval and when it stops.
+func (n *NasneCollector) SetStateFile(path string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("checkpoint interval must be positive: %v", interval)
+	}
+
+	n.statePath = path
+	n.checkpointInterval = interval
+	return nil
+}
+
+// AddState adds s to the state saved to the state file as name. It must be
+// called before LoadState.
+func (n *NasneCollector) AddState(name string, s State) {
+	n.states = append(n.states, namedState{name: name, state: s})
+}
+
+// LoadState restores the state of collectors from the state file. A missing
+// file is not an error. A corrupted file is renamed with the suffix ".corrupt"
+// and the collectors start without state.
+func (n *NasneCollector) LoadState() error {
+	if n.statePath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(n.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s := &stateFile{}
+	if err := json.Unmarshal(data, s); err != nil {
+		n.logger.Warn("discard corrupted state file", "path", n.statePath, "err", err)
+		return os.Rename(n.statePath, n.statePath+".corrupt")
+	}
+	if s.Version != stateVersion {
+		n.logger.Warn("discard state file of another version", "path", n.statePath, "version", s.Version, "expected", stateVersion)
+		return nil
+	}
+
+	for _, c := range n.collectors {
+		sc, ok := c.collector.(statefulCollector)
+		if !ok {
+			continue
+		}
+		data, ok := s.Collectors[c.name]
+		if !ok {
+			continue
+		}
+		if err := sc.loadState(data); err != nil {
+			n.logger.Warn("discard corrupted state of collector", "path", n.statePath, "collector", c.name, "err", err)
+		}
+	}
+	for _, st := range n.states {
+		data, ok := s.States[st.name]
+		if !ok {
+			continue
+		}
+		if err := st.state.LoadState(data); err != nil {
+			n.logger.Warn("discard corrupted state", "path", n.statePath, "state", st.name, "err", err)
+		}
+	}
+
+	n.logger.Info("state loaded", "path", n.statePath, "saved_at", s.SavedAt)
+	return nil
+}
+
+// SaveState saves the state of collectors to the state file.
+func (n *NasneCollector) SaveState() error {
+	if n.statePath == "" {
+		return nil
+	}
+
+	n.stateMu.Lock()
+	defer n.stateMu.Unlock()
+
+	s := &stateFile{
+		Version:    stateVersion,
+		SavedAt:    time.Now(),
+		Collectors: map[string]json.RawMessage{},
+	}
+	for _, c := range n.collectors {
+		sc, ok := c.collector.(statefulCollector)
+		if !ok {
+			continue
+		}
+		data, err := sc.saveState()
+		if err != nil {
+			return fmt.Errorf("failed to save state of %v: %v", c.name, err)
+		}
+		s.Collectors[c.name] = data
+	}
+	for _, st := range n.states {
+		data, err := st.state.SaveState()
+		if err != nil {
+			return fmt.Errorf("failed to save state of %v: %v", st.name, err)
+		}
+		if s.States == nil {
+			s.States = map[string]json.RawMessage{}
+		}
+		s.States[st.name] = data
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// The file is synced and then renamed so that a crash or a power loss
+	// never leaves a partial file.
+	dir := filepath.Dir(n.statePath)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(n.statePath)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), n.statePath); err != nil {
+		return err
+	}
+
+	// The directory is synced to persist the rename.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// runCheckpoint saves the state every checkpoint interval until done is closed.
+func (n *NasneCollector) runCheckpoint(done <-chan struct{}) {
+	ticker := time.NewTicker(n.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := n.SaveState(); err != nil {
+			n.logger.Error("failed to save state", "path", n.statePath, "err", err)
+		}
+	}
+}
+
+type counterState struct {
+	Labels prometheus.Labels `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// saveCounterVec returns the values of the counters in cv.
+func saveCounterVec(cv *prometheus.CounterVec) ([]counterState, error) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		cv.Collect(ch)
+		close(ch)
+	}()
+
+	var ms []prometheus.Metric
+	for m := range ch {
+		ms = append(ms, m)
+	}
+
+	var states []counterState
+	for _, m := range ms {
+		d := &dto.Metric{}
+		if err := m.Write(d); err != nil {
+			return nil, err
+		}
+
+		labels := prometheus.Labels{}
+		for _, lp := range d.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		states = append(states, counterState{Labels: labels, Value: d.GetCounter().GetValue()})
+	}
+
+	return states, nil
+}
+
+// loadCounterVec validates states and returns a function which adds their
+// values to the counters in cv. Nothing is added until the function is called,
+// so that the counters of several vecs can be restored together.
+func loadCounterVec(cv *prometheus.CounterVec, states []counterState) (func(), error) {
+	cs := make([]prometheus.Counter, len(states))
+	for i, s := range states {
+		if s.Value < 0 {
+			return nil, fmt.Errorf("negative counter: %v", s.Value)
+		}
+		c, err := cv.GetMetricWith(s.Labels)
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+
+	return func() {
+		for i, c := range cs {
+			c.Add(states[i].Value)
+		}
+	}, nil
+}
@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeState struct {
+	data json.RawMessage
+}
+
+func (s *fakeState) SaveState() (json.RawMessage, error) {
+	return s.data, nil
+}
+
+func (s *fakeState) LoadState(data json.RawMessage) error {
+	s.data = data
+	return nil
+}
+
+func TestAddState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	newCollector := func(s State) *NasneCollector {
+		n, err := NewNasneCollector(slog.New(slog.DiscardHandler), []string{"192.0.2.1"}, []string{"tuner"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.SetStateFile(path, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		n.AddState("events", s)
+		return n
+	}
+
+	if err := newCollector(&fakeState{data: json.RawMessage(`{"reserved":{}}`)}).SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeState{}
+	if err := newCollector(s).LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(s.data) != `{"reserved":{}}` {
+		t.Errorf("loaded state = %s, want the saved state", s.data)
+	}
+}
@@ -0,0 +1,206 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newFakeNasne returns an HTTP client whose requests to any nasne are sent to
+// a fake nasne. dtcpipClientListGet fails with an errorcode.
+func newFakeNasne(t *testing.T) *http.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status/boxNameGet":
+			w.Write([]byte(`{"errorcode":0,"name":"nasne1"}`))
+		case "/status/boxStatusListGet":
+			w.Write([]byte(`{"errorcode":0,"tuningStatus":{"status":3,"networkId":32736,"transportStreamId":32736,"serviceId":1024}}`))
+		case "/status/dtcpipClientListGet":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"errorcode":7}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	d := &net.Dialer{}
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}}
+}
+
+var (
+	spanRecorder      = tracetest.NewSpanRecorder()
+	setTracerProvider sync.Once
+)
+
+func TestCollectSpans(t *testing.T) {
+	// The global TracerProvider can be set only once.
+	setTracerProvider.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+
+	n, err := NewNasneCollector(slog.New(slog.DiscardHandler), []string{"192.0.2.1"}, []string{"tuner", "dtcpip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetHTTPClient(newFakeNasne(t))
+
+	if err := n.CollectOnce(context.Background()); err == nil {
+		t.Fatal("CollectOnce() succeeded, want the error of dtcpip")
+	}
+
+	// The spans of the earlier runs are in other traces.
+	ended := spanRecorder.Ended()
+	traceID := ended[len(ended)-1].SpanContext().TraceID()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range ended {
+		if s.SpanContext().TraceID() != traceID {
+			continue
+		}
+		if _, ok := spans[s.Name()]; ok {
+			t.Fatalf("span %v ended twice", s.Name())
+		}
+		spans[s.Name()] = s
+	}
+
+	// The tree of the spans, from each span to its parent.
+	tree := []struct {
+		name   string
+		parent string
+		kind   trace.SpanKind
+		status codes.Code
+		attrs  map[attribute.Key]attribute.Value
+	}{
+		{
+			name:   "collect",
+			kind:   trace.SpanKindInternal,
+			status: codes.Error,
+		},
+		{
+			name:   "collect.box",
+			parent: "collect",
+			kind:   trace.SpanKindInternal,
+			status: codes.Error,
+			attrs: map[attribute.Key]attribute.Value{
+				"net.peer.name": attribute.StringValue("192.0.2.1"),
+				"nasne.box":     attribute.StringValue("192.0.2.1"),
+				"nasne.name":    attribute.StringValue("nasne1"),
+			},
+		},
+		{
+			name:   "status/boxNameGet",
+			parent: "collect.box",
+			kind:   trace.SpanKindClient,
+			attrs: map[attribute.Key]attribute.Value{
+				"nasne.endpoint":            attribute.StringValue("status/boxNameGet"),
+				"nasne.port":                attribute.IntValue(64210),
+				"nasne.errorcode":           attribute.IntValue(0),
+				"http.response.status_code": attribute.IntValue(200),
+			},
+		},
+		{
+			name:   "collector tuner",
+			parent: "collect.box",
+			kind:   trace.SpanKindInternal,
+			attrs: map[attribute.Key]attribute.Value{
+				"nasne.collector": attribute.StringValue("tuner"),
+			},
+		},
+		{
+			name:   "status/boxStatusListGet",
+			parent: "collector tuner",
+			kind:   trace.SpanKindClient,
+			attrs: map[attribute.Key]attribute.Value{
+				"nasne.box":       attribute.StringValue("192.0.2.1"),
+				"nasne.endpoint":  attribute.StringValue("status/boxStatusListGet"),
+				"nasne.port":      attribute.IntValue(64210),
+				"nasne.errorcode": attribute.IntValue(0),
+			},
+		},
+		{
+			name:   "collector dtcpip",
+			parent: "collect.box",
+			kind:   trace.SpanKindInternal,
+			status: codes.Error,
+			attrs: map[attribute.Key]attribute.Value{
+				"nasne.collector": attribute.StringValue("dtcpip"),
+			},
+		},
+		{
+			name:   "status/dtcpipClientListGet",
+			parent: "collector dtcpip",
+			kind:   trace.SpanKindClient,
+			status: codes.Error,
+			attrs: map[attribute.Key]attribute.Value{
+				"nasne.endpoint":            attribute.StringValue("status/dtcpipClientListGet"),
+				"nasne.port":                attribute.IntValue(64210),
+				"nasne.errorcode":           attribute.IntValue(7),
+				"http.response.status_code": attribute.IntValue(500),
+			},
+		},
+	}
+
+	if len(spans) != len(tree) {
+		var names []string
+		for name := range spans {
+			names = append(names, name)
+		}
+		t.Fatalf("spans = %v, want %d spans", names, len(tree))
+	}
+
+	root := spans["collect"]
+	for _, want := range tree {
+		s, ok := spans[want.name]
+		if !ok {
+			t.Errorf("span %v not found", want.name)
+			continue
+		}
+
+		if s.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("%v is not in the trace of collect", want.name)
+		}
+		if want.parent == "" {
+			if s.Parent().IsValid() {
+				t.Errorf("%v has parent %v, want a root", want.name, s.Parent().SpanID())
+			}
+		} else if parent, ok := spans[want.parent]; ok && s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("parent of %v = %v, want %v", want.name, s.Parent().SpanID(), want.parent)
+		}
+
+		if s.SpanKind() != want.kind {
+			t.Errorf("kind of %v = %v, want %v", want.name, s.SpanKind(), want.kind)
+		}
+		if s.Status().Code != want.status {
+			t.Errorf("status of %v = %v, want %v", want.name, s.Status().Code, want.status)
+		}
+		if want.status == codes.Error && s.Status().Description == "" {
+			t.Errorf("status description of %v is empty, want the error", want.name)
+		}
+
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range s.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		for k, v := range want.attrs {
+			if got, ok := attrs[k]; !ok || got != v {
+				t.Errorf("%v of %v = %v, want %v", k, want.name, got.Emit(), v.Emit())
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("tuner", defaultEnabled, newTunerCollector)
+}
+
+type tunerCollector struct {
+	recordingsGauge *prometheus.GaugeVec
+}
+
+func newTunerCollector() Collector {
+	return &tunerCollector{
+		recordingsGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "recordings",
+				Help:      "Number of recordings.",
+			},
+			[]string{
+				labelName,
+			},
+		),
+	}
+}
+
+func (c *tunerCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.recordingsGauge}
+}
+
+func (c *tunerCollector) Update(ctx context.Context, client *nasneclient.NasneClient, commonLabel prometheus.Labels, snap *Snapshot) error {
+	boxStatusList, err := client.GetBoxStatusList(ctx)
+	if err != nil {
+		return err
+	}
+
+	var recordTotal float64
+	if boxStatusList.TuningStatus.Status == nasneclient.TuningStatusRecording {
+		recordTotal = 1
+	}
+
+	c.recordingsGauge.With(commonLabel).Set(recordTotal)
+	snap.BoxStatus = boxStatusList
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package event
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "nasne"
+
+	labelType = "type"
+)
+
+// Detector detects events from the differences between successive snapshots
+// of each nasne. Nothing is detected from the first snapshot of a response,
+// which has nothing to be compared with.
+type Detector struct {
+	mu    sync.Mutex
+	boxes map[string]*boxState
+
+	eventsCounter *prometheus.CounterVec
+}
+
+// boxState is the last observed state of a nasne. nil fields are not
+// observed yet.
+type boxState struct {
+	reachable *bool
+	recording *bool
+	reserved  map[string]*nasneclient.ReservedListItem
+	mounted   map[int]bool
+	clients   map[int]bool
+}
+
+// NewDetector returns a Detector.
+func NewDetector() *Detector {
+	return &Detector{
+		boxes: map[string]*boxState{},
+
+		eventsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "events_total",
+				Help:      "Number of detected events.",
+			},
+			[]string{
+				labelType,
+			},
+		),
+	}
+}
+
+// Collectors returns the metrics to be registered.
+func (d *Detector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{d.eventsCounter}
+}
+
+// Detect returns the events since the last snapshot of the same nasne.
+func (d *Detector) Detect(s *collector.Snapshot) []*Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, ok := d.boxes[s.Addr]
+	if !ok {
+		b = &boxState{}
+		d.boxes[s.Addr] = b
+	}
+
+	var events []*Event
+	add := func(t Type, data map[string]interface{}) {
+		events = append(events, newEvent(t, s.Addr, s.Name, s.Time, data))
+		d.eventsCounter.WithLabelValues(string(t)).Inc()
+	}
+
+	if b.reachable != nil && *b.reachable != s.Reachable {
+		if s.Reachable {
+			add(TypeBoxUp, nil)
+		} else {
+			add(TypeBoxDown, map[string]interface{}{"error": s.Error})
+		}
+	}
+	reachable := s.Reachable
+	b.reachable = &reachable
+
+	if s.BoxStatus != nil {
+		recording := s.BoxStatus.TuningStatus.Status == nasneclient.TuningStatusRecording
+		if b.recording != nil && *b.recording != recording {
+			data := map[string]interface{}{"service_id": s.BoxStatus.TuningStatus.ServiceId}
+			if recording {
+				add(TypeRecordingStarted, data)
+			} else {
+				add(TypeRecordingFinished, data)
+			}
+		}
+		b.recording = &recording
+	}
+
+	if s.Reserved != nil {
+		reserved := map[string]*nasneclient.ReservedListItem{}
+		for _, item := range s.Reserved.Item {
+			reserved[item.ID] = item
+
+			if b.reserved == nil {
+				continue
+			}
+			last, ok := b.reserved[item.ID]
+			if !ok {
+				add(TypeReservationAdded, map[string]interface{}{"id": item.ID, "title": item.Title})
+			}
+			// Conflicts which can still be recorded are not reported.
+			if item.ConflictID == nasneclient.ConflictIDConflictNG && (!ok || last.ConflictID != nasneclient.ConflictIDConflictNG) {
+				add(TypeConflictAppeared, map[string]interface{}{"id": item.ID, "title": item.Title, "conflict_id": item.ConflictID})
+			}
+		}
+		b.reserved = reserved
+	}
+
+	if s.HDD != nil {
+		mounted := map[int]bool{}
+		for _, hdd := range s.HDD {
+			mounted[hdd.ID] = hdd.MountStatus == nasneclient.MountStatusMounted
+
+			if b.mounted[hdd.ID] && !mounted[hdd.ID] {
+				add(TypeHDDUnmounted, map[string]interface{}{"id": hdd.ID, "hdd_name": hdd.Name, "serial_number": hdd.SerialNumber})
+			}
+		}
+		// A removed HDD is unmounted too.
+		for id, m := range b.mounted {
+			if _, ok := mounted[id]; !ok && m {
+				add(TypeHDDUnmounted, map[string]interface{}{"id": id})
+			}
+		}
+		b.mounted = mounted
+	}
+
+	if s.DTCPIPClients != nil {
+		clients := map[int]bool{}
+		for _, c := range s.DTCPIPClients.Client {
+			clients[c.ID] = true
+
+			if b.clients != nil && !b.clients[c.ID] {
+				add(TypeDTCPIPClientConnected, map[string]interface{}{"id": c.ID, "client_name": c.Name, "ip_addr": c.IpAddr, "mac_addr": c.MacAddr})
+			}
+		}
+		b.clients = clients
+	}
+
+	return events
+}
+
+// detectorState is the saved state of Detector. Only the reservations are
+// saved, so that the reservations added while the exporter is stopped are
+// detected after a restart.
+type detectorState struct {
+	// Reserved holds the conflict IDs of the known reservations of each
+	// nasne by ID.
+	Reserved map[string]map[string]int `json:"reserved"`
+}
+
+// SaveState returns the known reservations of each nasne.
+func (d *Detector) SaveState() (json.RawMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := &detectorState{Reserved: map[string]map[string]int{}}
+	for addr, b := range d.boxes {
+		if b.reserved == nil {
+			continue
+		}
+		reserved := map[string]int{}
+		for id, item := range b.reserved {
+			reserved[id] = item.ConflictID
+		}
+		s.Reserved[addr] = reserved
+	}
+
+	return json.Marshal(s)
+}
+
+// LoadState restores the known reservations saved by SaveState.
+func (d *Detector) LoadState(data json.RawMessage) error {
+	s := &detectorState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for addr, ids := range s.Reserved {
+		reserved := map[string]*nasneclient.ReservedListItem{}
+		for id, conflictID := range ids {
+			reserved[id] = &nasneclient.ReservedListItem{ID: id, ConflictID: conflictID}
+		}
+
+		b, ok := d.boxes[addr]
+		if !ok {
+			b = &boxState{}
+			d.boxes[addr] = b
+		}
+		b.reserved = reserved
+	}
+	return nil
+}
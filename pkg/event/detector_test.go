@@ -0,0 +1,228 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+func boxStatus(status nasneclient.TuningStatus) *nasneclient.BoxStatusList {
+	return &nasneclient.BoxStatusList{TuningStatus: nasneclient.BoxStatusListTuningStatus{Status: status, ServiceId: 1024}}
+}
+
+func reserved(conflictIDs ...int) *nasneclient.ReservedList {
+	l := &nasneclient.ReservedList{}
+	for i, id := range conflictIDs {
+		l.Item = append(l.Item, &nasneclient.ReservedListItem{ID: string(rune('a' + i)), Title: "title", ConflictID: id})
+	}
+	return l
+}
+
+func hdds(statuses ...nasneclient.MountStatus) []*nasneclient.HDDInfoHDD {
+	var l []*nasneclient.HDDInfoHDD
+	for i, s := range statuses {
+		l = append(l, &nasneclient.HDDInfoHDD{ID: i, MountStatus: s})
+	}
+	return l
+}
+
+func clients(ids ...int) *nasneclient.DTCPIPClientList {
+	l := &nasneclient.DTCPIPClientList{Number: len(ids)}
+	for _, id := range ids {
+		l.Client = append(l.Client, &nasneclient.DTCPIPClientListClient{ID: id})
+	}
+	return l
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name       string
+		last, next collector.Snapshot
+		want       []Type
+		wantData   map[string]interface{}
+	}{
+		{
+			name: "recording started",
+			last: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(0)},
+			next: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(nasneclient.TuningStatusRecording)},
+			want: []Type{TypeRecordingStarted},
+			wantData: map[string]interface{}{
+				"service_id": 1024,
+			},
+		},
+		{
+			name: "recording finished",
+			last: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(nasneclient.TuningStatusRecording)},
+			next: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(0)},
+			want: []Type{TypeRecordingFinished},
+		},
+		{
+			name: "still recording",
+			last: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(nasneclient.TuningStatusRecording)},
+			next: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(nasneclient.TuningStatusRecording)},
+		},
+		{
+			// The box status is not collected in the next snapshot.
+			name: "recording not observed",
+			last: collector.Snapshot{Reachable: true, BoxStatus: boxStatus(nasneclient.TuningStatusRecording)},
+			next: collector.Snapshot{Reachable: true},
+		},
+		{
+			name: "box down",
+			last: collector.Snapshot{Reachable: true},
+			next: collector.Snapshot{Error: "timeout"},
+			want: []Type{TypeBoxDown},
+			wantData: map[string]interface{}{
+				"error": "timeout",
+			},
+		},
+		{
+			name: "box up",
+			last: collector.Snapshot{},
+			next: collector.Snapshot{Reachable: true},
+			want: []Type{TypeBoxUp},
+		},
+		{
+			name: "reservation added",
+			last: collector.Snapshot{Reachable: true, Reserved: reserved()},
+			next: collector.Snapshot{Reachable: true, Reserved: reserved(0)},
+			want: []Type{TypeReservationAdded},
+		},
+		{
+			name: "reservation added with conflict",
+			last: collector.Snapshot{Reachable: true, Reserved: reserved()},
+			next: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictNG)},
+			want: []Type{TypeReservationAdded, TypeConflictAppeared},
+		},
+		{
+			name: "conflict appeared",
+			last: collector.Snapshot{Reachable: true, Reserved: reserved(0)},
+			next: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictNG)},
+			want: []Type{TypeConflictAppeared},
+			wantData: map[string]interface{}{
+				"id":          "a",
+				"title":       "title",
+				"conflict_id": nasneclient.ConflictIDConflictNG,
+			},
+		},
+		{
+			// The reservation becomes unrecordable.
+			name: "recordable conflict becomes unrecordable",
+			last: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictOK)},
+			next: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictNG)},
+			want: []Type{TypeConflictAppeared},
+		},
+		{
+			// The reservation can still be recorded.
+			name: "recordable conflict",
+			last: collector.Snapshot{Reachable: true, Reserved: reserved(0)},
+			next: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictOK)},
+		},
+		{
+			name: "conflict continues",
+			last: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictNG)},
+			next: collector.Snapshot{Reachable: true, Reserved: reserved(nasneclient.ConflictIDConflictNG)},
+		},
+		{
+			name: "HDD unmounted",
+			last: collector.Snapshot{Reachable: true, HDD: hdds(nasneclient.MountStatusMounted, nasneclient.MountStatusMounted)},
+			next: collector.Snapshot{Reachable: true, HDD: hdds(nasneclient.MountStatusMounted, 0)},
+			want: []Type{TypeHDDUnmounted},
+		},
+		{
+			name: "HDD removed",
+			last: collector.Snapshot{Reachable: true, HDD: hdds(nasneclient.MountStatusMounted, nasneclient.MountStatusMounted)},
+			next: collector.Snapshot{Reachable: true, HDD: hdds(nasneclient.MountStatusMounted)},
+			want: []Type{TypeHDDUnmounted},
+			wantData: map[string]interface{}{
+				"id": 1,
+			},
+		},
+		{
+			name: "HDD mounted",
+			last: collector.Snapshot{Reachable: true, HDD: hdds(0)},
+			next: collector.Snapshot{Reachable: true, HDD: hdds(nasneclient.MountStatusMounted)},
+		},
+		{
+			name: "DTCP-IP client connected",
+			last: collector.Snapshot{Reachable: true, DTCPIPClients: clients(1)},
+			next: collector.Snapshot{Reachable: true, DTCPIPClients: clients(1, 2)},
+			want: []Type{TypeDTCPIPClientConnected},
+		},
+		{
+			name: "DTCP-IP client disconnected",
+			last: collector.Snapshot{Reachable: true, DTCPIPClients: clients(1, 2)},
+			next: collector.Snapshot{Reachable: true, DTCPIPClients: clients(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDetector()
+
+			last, next := tt.last, tt.next
+			last.Addr, next.Addr = "192.0.2.1", "192.0.2.1"
+			last.Time = time.Date(2018, 1, 1, 21, 0, 0, 0, time.UTC)
+			next.Time = last.Time.Add(time.Minute)
+
+			// Nothing is detected from the first snapshot.
+			if events := d.Detect(&last); len(events) != 0 {
+				t.Fatalf("events of the first snapshot = %v, want none", events)
+			}
+
+			events := d.Detect(&next)
+			var got []Type
+			for _, e := range events {
+				got = append(got, e.Type)
+				if e.Addr != next.Addr || !e.Time.Equal(next.Time) || e.ID == "" {
+					t.Errorf("event = %+v, want addr, time and ID of the snapshot", e)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			if tt.wantData != nil && !reflect.DeepEqual(events[0].Data, tt.wantData) {
+				t.Errorf("data = %v, want %v", events[0].Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestDetectBoxes(t *testing.T) {
+	d := NewDetector()
+
+	// The snapshots of other nasnes are not compared.
+	d.Detect(&collector.Snapshot{Addr: "192.0.2.1", Reachable: true})
+	if events := d.Detect(&collector.Snapshot{Addr: "192.0.2.2"}); len(events) != 0 {
+		t.Errorf("events = %v, want none", events)
+	}
+}
+
+func TestDetectorState(t *testing.T) {
+	d := NewDetector()
+	d.Detect(&collector.Snapshot{Addr: "192.0.2.1", Reachable: true, Reserved: reserved(0, nasneclient.ConflictIDConflictNG)})
+
+	data, err := d.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewDetector()
+	if err := restored.LoadState(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the reservation added while stopped is detected, and the known
+	// conflict is not reported again.
+	events := restored.Detect(&collector.Snapshot{Addr: "192.0.2.1", Reachable: true, Reserved: reserved(0, nasneclient.ConflictIDConflictNG, 0)})
+	if len(events) != 1 || events[0].Type != TypeReservationAdded || events[0].Data["id"] != "c" {
+		t.Errorf("events = %v, want reservation_added of c", events)
+	}
+
+	if err := restored.LoadState([]byte("{")); err == nil {
+		t.Error("LoadState() of a corrupted state succeeded")
+	}
+}
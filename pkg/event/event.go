@@ -0,0 +1,72 @@
+// Package event detects the changes of nasne from successive snapshots of the
+// collector and delivers them as events.
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	TypeRecordingStarted      Type = "recording_started"
+	TypeRecordingFinished     Type = "recording_finished"
+	TypeReservationAdded      Type = "reservation_added"
+	TypeConflictAppeared      Type = "conflict_appeared"
+	TypeHDDUnmounted          Type = "hdd_unmounted"
+	TypeBoxDown               Type = "box_down"
+	TypeBoxUp                 Type = "box_up"
+	TypeDTCPIPClientConnected Type = "dtcpip_client_connected"
+)
+
+// Types is the list of all types of events.
+var Types = []Type{
+	TypeRecordingStarted,
+	TypeRecordingFinished,
+	TypeReservationAdded,
+	TypeConflictAppeared,
+	TypeHDDUnmounted,
+	TypeBoxDown,
+	TypeBoxUp,
+	TypeDTCPIPClientConnected,
+}
+
+// ParseType returns the Type named s.
+func ParseType(s string) (Type, error) {
+	for _, t := range Types {
+		if string(t) == s {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type: %v", s)
+}
+
+// Event is a change of a nasne.
+type Event struct {
+	// ID identifies the event, so that receivers can ignore redelivered events.
+	ID   string    `json:"id"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Addr string    `json:"addr"`
+	Name string    `json:"name"`
+	// Data holds the details of the event, which depend on Type.
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+func newEvent(t Type, addr, name string, now time.Time, data map[string]interface{}) *Event {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return &Event{
+		ID:   hex.EncodeToString(b),
+		Type: t,
+		Time: now,
+		Addr: addr,
+		Name: name,
+		Data: data,
+	}
+}
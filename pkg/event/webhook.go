@@ -0,0 +1,168 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/retry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// webhookQueueSize is the number of events waiting to be delivered. Events
+	// are dropped while the queue is full.
+	webhookQueueSize = 100
+
+	// SignatureHeader is the header of the HMAC-SHA256 signature of the body,
+	// such as "sha256=<hex>".
+	SignatureHeader = "X-Nasne-Signature-256"
+	// EventHeader is the header of the type of the event.
+	EventHeader = "X-Nasne-Event"
+
+	labelResult = "result"
+
+	resultSuccess = "success"
+	resultFailure = "failure"
+	resultDropped = "dropped"
+)
+
+// Webhook delivers events to URLs with HTTP POST requests whose bodies are
+// the events in JSON.
+type Webhook struct {
+	urls   []string
+	secret []byte
+	types  map[Type]bool
+	retry  int
+	client *http.Client
+	logger *slog.Logger
+
+	queue chan *Event
+
+	deliveriesCounter *prometheus.CounterVec
+}
+
+// NewWebhook returns a Webhook which delivers the events of types to urls. All
+// types are delivered if types is empty. The bodies are signed with secret if
+// it is not empty.
+func NewWebhook(logger *slog.Logger, urls []string, secret []byte, types []Type, retry int) *Webhook {
+	var typeSet map[Type]bool
+	if len(types) > 0 {
+		typeSet = map[Type]bool{}
+		for _, t := range types {
+			typeSet[t] = true
+		}
+	}
+
+	return &Webhook{
+		urls:   urls,
+		secret: secret,
+		types:  typeSet,
+		retry:  retry,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		queue:  make(chan *Event, webhookQueueSize),
+
+		deliveriesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "webhook_deliveries_total",
+				Help:      "Number of deliveries of events to webhooks.",
+			},
+			[]string{
+				labelResult,
+			},
+		),
+	}
+}
+
+// Collectors returns the metrics to be registered.
+func (w *Webhook) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{w.deliveriesCounter}
+}
+
+// Send queues e to be delivered by Run. It never blocks.
+func (w *Webhook) Send(e *Event) {
+	if w.types != nil && !w.types[e.Type] {
+		return
+	}
+
+	select {
+	case w.queue <- e:
+	default:
+		w.logger.Warn("drop event because the webhook queue is full", "type", e.Type, "id", e.ID)
+		w.deliveriesCounter.WithLabelValues(resultDropped).Add(float64(len(w.urls)))
+	}
+}
+
+// Run delivers the queued events until ctx is canceled.
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.queue:
+			w.deliver(ctx, e)
+		}
+	}
+}
+
+func (w *Webhook) deliver(ctx context.Context, e *Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		w.logger.Error("failed to marshal event", "type", e.Type, "id", e.ID, "err", err)
+		return
+	}
+
+	for _, url := range w.urls {
+		err := retry.Do(ctx, w.logger.With("url", url), w.retry, func() error {
+			return w.post(ctx, url, e.Type, body)
+		})
+		if err != nil {
+			w.logger.Error("webhook failed", "url", url, "type", e.Type, "id", e.ID, "err", err)
+			w.deliveriesCounter.WithLabelValues(resultFailure).Inc()
+			continue
+		}
+
+		w.logger.Debug("webhook delivered", "url", url, "type", e.Type, "id", e.ID)
+		w.deliveriesCounter.WithLabelValues(resultSuccess).Inc()
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, url string, t Type, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(t))
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	}
+
+	res, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return retry.Recoverable(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	return retry.CheckResponseStatus(url, res.StatusCode)
+}
+
+// Sign returns the signature of body with secret in the format of
+// SignatureHeader. Receivers can verify the body by comparing it with the
+// header with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,230 @@
+package event
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// delivery is a request received by sink.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// sink is a webhook receiver which responds with the next status of statuses,
+// or 204 if none is left.
+type sink struct {
+	mu         sync.Mutex
+	statuses   []int
+	deliveries []delivery
+	received   chan struct{}
+}
+
+func newSink(t *testing.T, statuses ...int) (*sink, string) {
+	s := &sink{statuses: statuses, received: make(chan struct{}, 10)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	return s, srv.URL
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, delivery{header: r.Header, body: body})
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(status)
+	s.received <- struct{}{}
+}
+
+// wait waits for n requests.
+func (s *sink) wait(t *testing.T, n int) []delivery {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d requests, want %d", i, n)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deliveries
+}
+
+// runWebhook runs w until the test ends.
+func runWebhook(t *testing.T, w *Webhook) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx)
+}
+
+func testEvent() *Event {
+	return &Event{
+		ID:   "0123456789abcdef",
+		Type: TypeRecordingStarted,
+		Time: time.Date(2018, 1, 1, 21, 0, 0, 0, time.UTC),
+		Addr: "192.0.2.1",
+		Name: "nasne1",
+		Data: map[string]interface{}{"service_id": 1024},
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	s, url := newSink(t)
+	secret := []byte("secret")
+
+	w := NewWebhook(slog.New(slog.DiscardHandler), []string{url}, secret, nil, 0)
+	runWebhook(t, w)
+	w.Send(testEvent())
+
+	d := s.wait(t, 1)[0]
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		EventHeader:    string(TypeRecordingStarted),
+	}
+	for k, want := range headers {
+		if got := d.header.Get(k); got != want {
+			t.Errorf("%v = %q, want %q", k, got, want)
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(d.body)
+	if got, want := d.header.Get(SignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("%v = %q, want %q", SignatureHeader, got, want)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(d.body, &got); err != nil {
+		t.Fatalf("invalid body %s: %v", d.body, err)
+	}
+	want := map[string]interface{}{
+		"id":   "0123456789abcdef",
+		"type": "recording_started",
+		"time": "2018-01-01T21:00:00Z",
+		"addr": "192.0.2.1",
+		"name": "nasne1",
+		"data": map[string]interface{}{"service_id": float64(1024)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("body = %v, want %v", got, want)
+	}
+}
+
+func TestWebhookNoSecret(t *testing.T) {
+	s, url := newSink(t)
+
+	w := NewWebhook(slog.New(slog.DiscardHandler), []string{url}, nil, nil, 0)
+	runWebhook(t, w)
+	w.Send(testEvent())
+
+	if got := s.wait(t, 1)[0].header.Get(SignatureHeader); got != "" {
+		t.Errorf("%v = %q, want none", SignatureHeader, got)
+	}
+}
+
+func TestWebhookTypes(t *testing.T) {
+	s, url := newSink(t)
+
+	w := NewWebhook(slog.New(slog.DiscardHandler), []string{url}, nil, []Type{TypeBoxDown}, 0)
+	runWebhook(t, w)
+
+	started := testEvent()
+	down := testEvent()
+	down.Type = TypeBoxDown
+	w.Send(started)
+	w.Send(down)
+
+	ds := s.wait(t, 1)
+	if got := ds[0].header.Get(EventHeader); got != string(TypeBoxDown) {
+		t.Errorf("%v = %q, want %q", EventHeader, got, TypeBoxDown)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retry        int
+		wantRequests int
+		wantResult   string
+	}{
+		{
+			name:         "success",
+			retry:        1,
+			wantRequests: 1,
+			wantResult:   resultSuccess,
+		},
+		{
+			name:         "retry on 5xx",
+			statuses:     []int{http.StatusBadGateway},
+			retry:        1,
+			wantRequests: 2,
+			wantResult:   resultSuccess,
+		},
+		{
+			name:         "give up after retries",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			retry:        1,
+			wantRequests: 2,
+			wantResult:   resultFailure,
+		},
+		{
+			name:         "no retry on 4xx",
+			statuses:     []int{http.StatusNotFound},
+			retry:        1,
+			wantRequests: 1,
+			wantResult:   resultFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, url := newSink(t, tt.statuses...)
+
+			w := NewWebhook(slog.New(slog.DiscardHandler), []string{url}, nil, nil, tt.retry)
+			// Deliver synchronously to see the result after the retries.
+			w.deliver(context.Background(), testEvent())
+
+			if got := len(s.wait(t, tt.wantRequests)); got != tt.wantRequests {
+				t.Errorf("received %d requests, want %d", got, tt.wantRequests)
+			}
+			if got := counterValue(t, w, tt.wantResult); got != 1 {
+				t.Errorf("%v deliveries = %v, want 1", tt.wantResult, got)
+			}
+		})
+	}
+}
+
+func counterValue(t *testing.T, w *Webhook, result string) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := w.deliveriesCounter.WithLabelValues(result).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
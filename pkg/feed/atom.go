@@ -0,0 +1,181 @@
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	atomNamespace = "http://www.w3.org/2005/Atom"
+
+	// defaultAtomLimit is the default number of entries of the feed.
+	defaultAtomLimit = 100
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published"`
+	Author    atomAuthor    `xml:"author"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Content   atomContent   `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// recordedEntry is a recorded title with its nasne.
+type recordedEntry struct {
+	box         box
+	id          string
+	title       string
+	description string
+	channel     string
+	start       time.Time
+	duration    time.Duration
+}
+
+// AtomHandler returns the handler which serves the recorded titles of all
+// nasnes in Atom, newest first. The query parameters "box" and "channel" limit
+// the titles to the nasne with the name or the address and to the channel,
+// and "limit" is the maximum number of entries.
+func (s *Store) AtomHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		filter := q.Get("box")
+		boxes := s.find(filter)
+		if filter != "" && len(boxes) == 0 {
+			http.Error(w, fmt.Sprintf("unknown box: %v", filter), http.StatusNotFound)
+			return
+		}
+
+		limit, err := parseLimit(q, defaultAtomLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries := recordedEntries(boxes, q.Get("channel"))
+		if len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		b, err := xml.MarshalIndent(renderAtom(entries, time.Now()), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		w.Write(b)
+	})
+}
+
+// recordedEntries returns the recorded titles of boxes on channel, or on all
+// channels if channel is empty, newest first.
+func recordedEntries(boxes []box, channel string) []*recordedEntry {
+	var entries []*recordedEntry
+
+	for _, bx := range boxes {
+		if bx.recorded == nil {
+			continue
+		}
+
+		for _, item := range bx.recorded.Item {
+			if channel != "" && item.ChannelName != channel {
+				continue
+			}
+			if item.StartDateTime.IsZero() {
+				continue
+			}
+
+			entries = append(entries, &recordedEntry{
+				box:         bx,
+				id:          item.ID,
+				title:       item.Title,
+				description: item.Description,
+				channel:     item.ChannelName,
+				start:       item.StartDateTime.Time,
+				duration:    item.Duration.Duration,
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].start.After(entries[j].start)
+	})
+
+	return entries
+}
+
+func renderAtom(entries []*recordedEntry, now time.Time) *atomFeed {
+	feed := &atomFeed{
+		Xmlns:   atomNamespace,
+		ID:      "urn:nasne_exporter:recorded",
+		Title:   "nasne recorded titles",
+		Updated: now.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "nasne_exporter"},
+	}
+	// The feed is updated when the newest title is recorded.
+	if len(entries) > 0 {
+		feed.Updated = entries[0].start.Add(entries[0].duration).UTC().Format(time.RFC3339)
+	}
+
+	for _, e := range entries {
+		boxName := e.box.name
+		if boxName == "" {
+			boxName = e.box.addr
+		}
+
+		var content strings.Builder
+		fmt.Fprintf(&content, "Channel: %v\n", e.channel)
+		fmt.Fprintf(&content, "Start: %v\n", e.start.Format("2006-01-02 15:04"))
+		fmt.Fprintf(&content, "Duration: %v\n", e.duration)
+		if e.description != "" {
+			fmt.Fprintf(&content, "\n%v\n", e.description)
+		}
+
+		entry := atomEntry{
+			ID:        "urn:nasne_exporter:recorded:" + e.box.addr + ":" + e.id,
+			Title:     e.title,
+			Updated:   e.start.Add(e.duration).UTC().Format(time.RFC3339),
+			Published: e.start.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: boxName},
+			Content:   atomContent{Type: "text", Body: content.String()},
+		}
+		if e.channel != "" {
+			entry.Category = &atomCategory{Term: e.channel}
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed
+}
@@ -0,0 +1,185 @@
+package feed
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+// parsedFeed is an Atom feed parsed independently of atomFeed.
+type parsedFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Entries []struct {
+		ID        string `xml:"id"`
+		Title     string `xml:"title"`
+		Updated   string `xml:"updated"`
+		Published string `xml:"published"`
+		Author    string `xml:"author>name"`
+		Category  struct {
+			Term string `xml:"term,attr"`
+		} `xml:"category"`
+	} `xml:"entry"`
+}
+
+func recordedTitle(id, title, channel string, start time.Time, duration time.Duration) *nasneclient.RecordedTitleListItem {
+	return &nasneclient.RecordedTitleListItem{
+		ID:            id,
+		Title:         title,
+		ChannelName:   channel,
+		StartDateTime: nasneclient.Time{Time: start},
+		Duration:      nasneclient.Duration{Duration: duration},
+	}
+}
+
+// newRecordedStore returns a Store with the recorded titles of two nasnes.
+func newRecordedStore() *Store {
+	jst := time.FixedZone("JST", 9*60*60)
+
+	s := NewStore()
+	s.Update(&collector.Snapshot{Addr: "192.0.2.1", Name: "nasne1", RecordedTitles: &nasneclient.RecordedTitleList{Item: []*nasneclient.RecordedTitleListItem{
+		recordedTitle("t1.ts", "ニュース", "ＮＨＫ総合・東京", time.Date(2018, 1, 1, 21, 0, 0, 0, jst), 30*time.Minute),
+		recordedTitle("t3.ts", "映画 <吹替版> & 字幕", "ＢＳ１", time.Date(2018, 1, 2, 19, 0, 0, 0, jst), 2*time.Hour),
+		// The titles with broken start times are skipped.
+		recordedTitle("t0.ts", "中断", "ＢＳ１", time.Time{}, 0),
+	}}})
+	s.Update(&collector.Snapshot{Addr: "192.0.2.2", RecordedTitles: &nasneclient.RecordedTitleList{Item: []*nasneclient.RecordedTitleListItem{
+		recordedTitle("t2.ts", "天気予報", "ＮＨＫ総合・東京", time.Date(2018, 1, 2, 6, 55, 0, 0, jst), 5*time.Minute),
+		// The same ID on another nasne is another title.
+		recordedTitle("t1.ts", "ドラマ", "ＢＳ１", time.Date(2018, 1, 1, 22, 0, 0, 0, jst), time.Hour),
+	}}})
+	return s
+}
+
+func TestAtomHandler(t *testing.T) {
+	h := newRecordedStore().AtomHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feeds/recorded.atom", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	var feed parsedFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to parse the feed: %v\n%s", err, rec.Body.String())
+	}
+
+	// The entries are newest first across the nasnes, and updated when the
+	// recording finished.
+	type entry struct {
+		id, title, updated, published, author, category string
+	}
+	want := []entry{
+		{"urn:nasne_exporter:recorded:192.0.2.1:t3.ts", "映画 <吹替版> & 字幕", "2018-01-02T12:00:00Z", "2018-01-02T10:00:00Z", "nasne1", "ＢＳ１"},
+		{"urn:nasne_exporter:recorded:192.0.2.2:t2.ts", "天気予報", "2018-01-01T22:00:00Z", "2018-01-01T21:55:00Z", "192.0.2.2", "ＮＨＫ総合・東京"},
+		{"urn:nasne_exporter:recorded:192.0.2.2:t1.ts", "ドラマ", "2018-01-01T14:00:00Z", "2018-01-01T13:00:00Z", "192.0.2.2", "ＢＳ１"},
+		{"urn:nasne_exporter:recorded:192.0.2.1:t1.ts", "ニュース", "2018-01-01T12:30:00Z", "2018-01-01T12:00:00Z", "nasne1", "ＮＨＫ総合・東京"},
+	}
+	var got []entry
+	for _, e := range feed.Entries {
+		got = append(got, entry{e.ID, e.Title, e.Updated, e.Published, e.Author, e.Category.Term})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries =\n%v\nwant\n%v", got, want)
+	}
+
+	if feed.ID != "urn:nasne_exporter:recorded" {
+		t.Errorf("feed ID = %q", feed.ID)
+	}
+	if feed.Updated != want[0].updated {
+		t.Errorf("feed updated = %q, want %q of the newest entry", feed.Updated, want[0].updated)
+	}
+	for _, e := range feed.Entries {
+		if _, err := time.Parse(time.RFC3339, e.Updated); err != nil {
+			t.Errorf("updated of %v: %v", e.ID, err)
+		}
+	}
+}
+
+func TestAtomHandlerFilters(t *testing.T) {
+	h := newRecordedStore().AtomHandler()
+
+	tests := []struct {
+		query    string
+		wantCode int
+		wantIDs  []string
+	}{
+		{
+			query:    "?box=nasne1",
+			wantCode: http.StatusOK,
+			wantIDs:  []string{"urn:nasne_exporter:recorded:192.0.2.1:t3.ts", "urn:nasne_exporter:recorded:192.0.2.1:t1.ts"},
+		},
+		{
+			query:    "?box=nasne3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			query:    "?channel=ＢＳ１",
+			wantCode: http.StatusOK,
+			wantIDs:  []string{"urn:nasne_exporter:recorded:192.0.2.1:t3.ts", "urn:nasne_exporter:recorded:192.0.2.2:t1.ts"},
+		},
+		{
+			query:    "?limit=1",
+			wantCode: http.StatusOK,
+			wantIDs:  []string{"urn:nasne_exporter:recorded:192.0.2.1:t3.ts"},
+		},
+		{
+			query:    "?limit=-1",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feeds/recorded.atom"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var feed parsedFeed
+			if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, e := range feed.Entries {
+				ids = append(ids, e.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestAtomHandlerEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewStore().AtomHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feeds/recorded.atom", nil))
+
+	var feed parsedFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 0 {
+		t.Errorf("entries = %v, want none", feed.Entries)
+	}
+	// Without entries, the feed is updated now.
+	if updated, err := time.Parse(time.RFC3339, feed.Updated); err != nil || time.Since(updated) > time.Minute {
+		t.Errorf("feed updated = %q, want now", feed.Updated)
+	}
+}
@@ -0,0 +1,173 @@
+package feed
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+	// icalLineLength is the maximum length of a line in octets.
+	icalLineLength = 75
+)
+
+// reservedEntry is a reservation with its nasne.
+type reservedEntry struct {
+	box  box
+	item *nasneclient.ReservedListItem
+}
+
+// CalendarHandler returns the handler which serves the reservations in
+// iCalendar, earliest first. The query parameters "box" and "channel" limit
+// the reservations to the nasne with the name or the address and to the
+// channel, and "limit" is the maximum number of events.
+func (s *Store) CalendarHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		filter := q.Get("box")
+		boxes := s.find(filter)
+		if filter != "" && len(boxes) == 0 {
+			http.Error(w, fmt.Sprintf("unknown box: %v", filter), http.StatusNotFound)
+			return
+		}
+
+		limit, err := parseLimit(q, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries := reservedEntries(boxes, q.Get("channel"))
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write([]byte(renderCalendar(entries, time.Now())))
+	})
+}
+
+// reservedEntries returns the reservations of boxes on channel, or on all
+// channels if channel is empty, earliest first. The reservations without
+// programs or start times are skipped.
+func reservedEntries(boxes []box, channel string) []*reservedEntry {
+	var entries []*reservedEntry
+
+	for _, bx := range boxes {
+		if bx.reserved == nil {
+			continue
+		}
+
+		for _, item := range bx.reserved.Item {
+			if channel != "" && item.ChannelName != channel {
+				continue
+			}
+			if item.EventID == nasneclient.EventIDNotFound {
+				continue
+			}
+			if item.StartDateTime.IsZero() {
+				continue
+			}
+
+			entries = append(entries, &reservedEntry{box: bx, item: item})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].item.StartDateTime.Before(entries[j].item.StartDateTime.Time)
+	})
+
+	return entries
+}
+
+func renderCalendar(entries []*reservedEntry, now time.Time) string {
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(foldICalLine(s))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//hatotaka//nasne_exporter//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:nasne")
+
+	for _, e := range entries {
+		bx, item := e.box, e.item
+
+		boxName := bx.name
+		if boxName == "" {
+			boxName = bx.addr
+		}
+
+		start := item.StartDateTime.Time
+		end := start.Add(item.Duration.Duration)
+
+		summary := item.Title
+		description := "nasne: " + boxName
+		switch item.ConflictID {
+		case nasneclient.ConflictIDConflictOK:
+			summary = "[Conflict] " + summary
+			description += "\nConflicts with another reservation but can be recorded."
+		case nasneclient.ConflictIDConflictNG:
+			summary = "[Conflict] " + summary
+			description += "\nConflicts with another reservation and can not be recorded."
+		}
+		if item.Description != "" {
+			description += "\n\n" + item.Description
+		}
+
+		line("BEGIN:VEVENT")
+		line("UID:" + escapeICalText(item.ID+"@"+bx.addr+".nasne_exporter"))
+		line("DTSTAMP:" + now.UTC().Format(icalTimeFormat))
+		line("DTSTART:" + start.UTC().Format(icalTimeFormat))
+		line("DTEND:" + end.UTC().Format(icalTimeFormat))
+		line("SUMMARY:" + escapeICalText(summary))
+		if item.ChannelName != "" {
+			line("LOCATION:" + escapeICalText(item.ChannelName))
+		}
+		line("DESCRIPTION:" + escapeICalText(description))
+		if item.ConflictID > 0 {
+			line("CATEGORIES:CONFLICT")
+		}
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	return b.String()
+}
+
+var icalTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICalText(s string) string {
+	return icalTextEscaper.Replace(s)
+}
+
+// foldICalLine folds s into lines of up to icalLineLength octets without
+// splitting UTF-8 characters.
+func foldICalLine(s string) string {
+	var b strings.Builder
+
+	length := 0
+	for _, r := range s {
+		n := utf8.RuneLen(r)
+		if length+n > icalLineLength {
+			// The continuation line starts with a space, which counts.
+			b.WriteString("\r\n ")
+			length = 1
+		}
+		b.WriteRune(r)
+		length += n
+	}
+
+	return b.String()
+}
@@ -0,0 +1,230 @@
+package feed
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var feedNow = time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func reservation(id, title, channel string, start time.Time) *nasneclient.ReservedListItem {
+	return &nasneclient.ReservedListItem{
+		ID:            id,
+		Title:         title,
+		StartDateTime: nasneclient.Time{Time: start},
+		Duration:      nasneclient.Duration{Duration: 30 * time.Minute},
+		ChannelName:   channel,
+	}
+}
+
+// newReservedStore returns a Store with the reservations of two nasnes.
+func newReservedStore() *Store {
+	jst := time.FixedZone("JST", 9*60*60)
+
+	conflict := reservation("r3", "映画,前編;吹替版\\字幕版", "ＢＳ１", time.Date(2018, 1, 2, 19, 0, 0, 0, jst))
+	conflict.ConflictID = nasneclient.ConflictIDConflictNG
+	conflict.Description = "一行目\n二行目"
+	long := reservation("r1", strings.Repeat("とても長いタイトル", 5), "ＮＨＫ総合・東京", time.Date(2018, 1, 1, 21, 0, 0, 0, jst))
+	long.ConflictID = nasneclient.ConflictIDConflictOK
+	notFound := reservation("r9", "未定", "ＮＨＫ総合・東京", time.Date(2018, 1, 3, 21, 0, 0, 0, jst))
+	notFound.EventID = nasneclient.EventIDNotFound
+
+	s := NewStore()
+	s.Update(&collector.Snapshot{Addr: "192.0.2.1", Name: "nasne1", Reserved: &nasneclient.ReservedList{Item: []*nasneclient.ReservedListItem{
+		conflict,
+		long,
+		notFound,
+		reservation("r0", "開始時刻なし", "ＮＨＫ総合・東京", time.Time{}),
+	}}})
+	s.Update(&collector.Snapshot{Addr: "192.0.2.2", Reserved: &nasneclient.ReservedList{Item: []*nasneclient.ReservedListItem{
+		reservation("r2", "ニュース", "ＮＨＫ総合・東京", time.Date(2018, 1, 2, 7, 0, 0, 0, jst)),
+	}}})
+	return s
+}
+
+func TestRenderCalendar(t *testing.T) {
+	got := renderCalendar(reservedEntries(newReservedStore().find(""), ""), feedNow)
+
+	golden := filepath.Join("testdata", "calendar.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("renderCalendar() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Every line ends with CRLF and is folded within 75 octets of UTF-8.
+	if !strings.HasSuffix(got, "\r\n") {
+		t.Error("the calendar does not end with CRLF")
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+		if strings.ContainsAny(l, "\r\n") {
+			t.Errorf("line %q contains a bare CR or LF", l)
+		}
+		if len(l) > icalLineLength {
+			t.Errorf("line %q has %d octets", l, len(l))
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("line %q splits a UTF-8 character", l)
+		}
+	}
+}
+
+func TestFoldICalLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "short", line: "SUMMARY:ニュース"},
+		{name: "exactly 75 octets", line: "SUMMARY:" + strings.Repeat("a", 67)},
+		{name: "ASCII", line: "DESCRIPTION:" + strings.Repeat("abcdefghij", 20)},
+		// The characters of 3 octets do not fit the boundaries of 75 octets.
+		{name: "UTF-8", line: "SUMMARY:" + strings.Repeat("あ", 60)},
+		{name: "mixed", line: "SUMMARY:" + strings.Repeat("aあ", 40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folded := foldICalLine(tt.line)
+
+			lines := strings.Split(folded, "\r\n")
+			for i, l := range lines {
+				if len(l) > icalLineLength {
+					t.Errorf("line %d has %d octets", i, len(l))
+				}
+				if !utf8.ValidString(l) {
+					t.Errorf("line %d %q splits a UTF-8 character", i, l)
+				}
+				if i > 0 && !strings.HasPrefix(l, " ") {
+					t.Errorf("continuation line %d %q does not start with a space", i, l)
+				}
+			}
+			if len(tt.line) <= icalLineLength && len(lines) != 1 {
+				t.Errorf("a line of %d octets is folded", len(tt.line))
+			}
+
+			if got := strings.Replace(folded, "\r\n ", "", -1); got != tt.line {
+				t.Errorf("unfolded = %q, want %q", got, tt.line)
+			}
+		})
+	}
+}
+
+func TestEscapeICalText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "a,b", want: `a\,b`},
+		{text: "a;b", want: `a\;b`},
+		{text: `a\b`, want: `a\\b`},
+		{text: "a\nb", want: `a\nb`},
+		{text: "a\r\nb", want: `a\nb`},
+		{text: `\n`, want: `\\n`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeICalText(tt.text); got != tt.want {
+			t.Errorf("escapeICalText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+var icalUID = regexp.MustCompile(`(?m)^UID:(\S+)\r$`)
+
+func TestCalendarHandler(t *testing.T) {
+	h := newReservedStore().CalendarHandler()
+
+	tests := []struct {
+		query    string
+		wantCode int
+		wantUIDs []string
+	}{
+		{
+			query:    "",
+			wantCode: http.StatusOK,
+			wantUIDs: []string{"r1@192.0.2.1.nasne_exporter", "r2@192.0.2.2.nasne_exporter", "r3@192.0.2.1.nasne_exporter"},
+		},
+		{
+			query:    "?box=nasne1",
+			wantCode: http.StatusOK,
+			wantUIDs: []string{"r1@192.0.2.1.nasne_exporter", "r3@192.0.2.1.nasne_exporter"},
+		},
+		{
+			query:    "?box=192.0.2.2",
+			wantCode: http.StatusOK,
+			wantUIDs: []string{"r2@192.0.2.2.nasne_exporter"},
+		},
+		{
+			query:    "?box=nasne3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			query:    "?channel=ＢＳ１",
+			wantCode: http.StatusOK,
+			wantUIDs: []string{"r3@192.0.2.1.nasne_exporter"},
+		},
+		{
+			query:    "?channel=ＢＳ１&box=192.0.2.2",
+			wantCode: http.StatusOK,
+		},
+		{
+			query:    "?limit=2",
+			wantCode: http.StatusOK,
+			wantUIDs: []string{"r1@192.0.2.1.nasne_exporter", "r2@192.0.2.2.nasne_exporter"},
+		},
+		{
+			query:    "?limit=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			query:    "?limit=x",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/calendar.ics"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/calendar; charset=utf-8" {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			var uids []string
+			for _, m := range icalUID.FindAllStringSubmatch(rec.Body.String(), -1) {
+				uids = append(uids, m[1])
+			}
+			if !reflect.DeepEqual(uids, tt.wantUIDs) {
+				t.Errorf("UIDs = %v, want %v", uids, tt.wantUIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Package feed serves the reservations and the recorded titles of nasnes as
+// feeds for calendar apps and feed readers.
+package feed
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+// Store keeps the latest responses of each nasne from the snapshots of the
+// collector.
+type Store struct {
+	mu    sync.Mutex
+	boxes map[string]*box
+}
+
+type box struct {
+	addr     string
+	name     string
+	reserved *nasneclient.ReservedList
+	recorded *nasneclient.RecordedTitleList
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{boxes: map[string]*box{}}
+}
+
+// Update updates the responses of the nasne of s. The responses not in s are
+// kept.
+func (s *Store) Update(snap *collector.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.boxes[snap.Addr]
+	if !ok {
+		b = &box{addr: snap.Addr}
+		s.boxes[snap.Addr] = b
+	}
+
+	if snap.Name != "" {
+		b.name = snap.Name
+	}
+	if snap.Reserved != nil {
+		b.reserved = snap.Reserved
+	}
+	if snap.RecordedTitles != nil {
+		b.recorded = snap.RecordedTitles
+	}
+}
+
+// find returns copies of the boxes whose name or address is filter, or all
+// boxes if filter is empty. They are sorted by address.
+func (s *Store) find(filter string) []box {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var boxes []box
+	for _, b := range s.boxes {
+		if filter == "" || filter == b.name || filter == b.addr {
+			boxes = append(boxes, *b)
+		}
+	}
+	sort.Slice(boxes, func(i, j int) bool {
+		return boxes[i].addr < boxes[j].addr
+	})
+
+	return boxes
+}
+
+// parseLimit returns the query parameter "limit" of q, or def if it is not
+// set. The limit must be positive.
+func parseLimit(q url.Values, def int) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit: %v", v)
+	}
+	return limit, nil
+}
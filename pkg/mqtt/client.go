@@ -0,0 +1,286 @@
+// Package mqtt publishes the state of nasne to an MQTT broker with the Home
+// Assistant MQTT discovery.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+)
+
+// This file implements the small subset of MQTT 3.1.1 needed to keep a
+// session with a will and publish retained messages with QoS 0.
+
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPingreq    = 12
+	packetDisconnect = 14
+
+	protocolLevel = 4
+
+	connectFlagUsername     = 0x80
+	connectFlagPassword     = 0x40
+	connectFlagWillRetain   = 0x20
+	connectFlagWill         = 0x04
+	connectFlagCleanSession = 0x02
+
+	publishFlagRetain = 0x01
+
+	keepAlive   = 60 * time.Second
+	dialTimeout = 10 * time.Second
+	// writeTimeout is the timeout of writing packets.
+	writeTimeout = 30 * time.Second
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// message is an application message.
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// conn is a connection to an MQTT broker.
+type conn struct {
+	c net.Conn
+	w *bufio.Writer
+
+	// done is closed when the connection is lost. err holds the cause.
+	done chan struct{}
+	err  error
+}
+
+// dial connects to the broker at rawurl, such as "tcp://192.0.2.1:1883" and
+// "ssl://192.0.2.1:8883". The broker publishes will when the connection is
+// lost without DISCONNECT. The connection must be kept alive with ping.
+func dial(ctx context.Context, rawurl, clientID, username, password string, will *message) (*conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &net.Dialer{Timeout: dialTimeout}
+
+	var c net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		c, err = d.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "ssl", "tls", "mqtts":
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: u.Hostname()}}
+		c, err = td.DialContext(ctx, "tcp", hostPort(u, "8883"))
+	default:
+		return nil, fmt.Errorf("unsupported scheme of MQTT broker: %v", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mc := &conn{c: c, w: bufio.NewWriter(c), done: make(chan struct{})}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	if err := mc.connect(clientID, username, password, will); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+
+	go mc.readLoop()
+	return mc, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+func (mc *conn) connect(clientID, username, password string, will *message) error {
+	var flags byte = connectFlagCleanSession
+	if will != nil {
+		flags |= connectFlagWill
+		if will.retain {
+			flags |= connectFlagWillRetain
+		}
+	}
+	if username != "" {
+		flags |= connectFlagUsername
+		if password != "" {
+			flags |= connectFlagPassword
+		}
+	}
+
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = append(body, byte(keepAlive/time.Second>>8), byte(keepAlive/time.Second))
+	body = appendString(body, clientID)
+	if will != nil {
+		body = appendString(body, will.topic)
+		body = appendString(body, string(will.payload))
+	}
+	if flags&connectFlagUsername != 0 {
+		body = appendString(body, username)
+	}
+	if flags&connectFlagPassword != 0 {
+		body = appendString(body, password)
+	}
+
+	if err := mc.writePacket(packetConnect<<4, body); err != nil {
+		return err
+	}
+	if err := mc.w.Flush(); err != nil {
+		return err
+	}
+
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(mc.c, ack); err != nil {
+		return err
+	}
+	if ack[0] != packetConnack<<4 || ack[1] != 2 {
+		return errors.New("unexpected response to MQTT CONNECT")
+	}
+	if ack[3] != 0 {
+		if msg, ok := connackErrors[ack[3]]; ok {
+			return fmt.Errorf("MQTT connection refused: %v", msg)
+		}
+		return fmt.Errorf("MQTT connection refused: return code %d", ack[3])
+	}
+
+	return nil
+}
+
+// readLoop reads the packets from the broker until the connection is lost.
+// The broker only sends PINGRESP after CONNACK, so the packets are discarded.
+// The broker is regarded as lost if it does not respond to ping in time.
+func (mc *conn) readLoop() {
+	r := bufio.NewReader(mc.c)
+	for {
+		mc.c.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+
+		if _, err := r.ReadByte(); err != nil {
+			mc.err = err
+			break
+		}
+		n, err := readRemainingLength(r)
+		if err != nil {
+			mc.err = err
+			break
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+			mc.err = err
+			break
+		}
+	}
+
+	close(mc.done)
+}
+
+func readRemainingLength(r io.ByteReader) (int, error) {
+	var n int
+	for i := uint(0); i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("malformed MQTT remaining length")
+}
+
+// ping sends PINGREQ, which must be sent within the keep alive.
+func (mc *conn) ping() error {
+	if err := mc.writePacket(packetPingreq<<4, nil); err != nil {
+		return err
+	}
+	return mc.flush()
+}
+
+// publish writes a PUBLISH packet with QoS 0. It is sent by flush.
+func (mc *conn) publish(topic string, payload []byte, retain bool) error {
+	var header byte = packetPublish << 4
+	if retain {
+		header |= publishFlagRetain
+	}
+
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+
+	return mc.writePacket(header, body)
+}
+
+// flush sends the written packets.
+func (mc *conn) flush() error {
+	mc.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return mc.w.Flush()
+}
+
+// close sends DISCONNECT and closes the connection. The will is discarded.
+func (mc *conn) close() error {
+	err := mc.writePacket(packetDisconnect<<4, nil)
+	if err == nil {
+		err = mc.flush()
+	}
+	if cerr := mc.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writePacket writes a packet to the buffer. The deadline is extended for
+// each packet, because the buffer is written to the connection when it is
+// full.
+func (mc *conn) writePacket(header byte, body []byte) error {
+	mc.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	if len(body) > 268435455 {
+		return fmt.Errorf("MQTT packet too large: %d bytes", len(body))
+	}
+
+	b := []byte{header}
+	// The remaining length is encoded in 7 bits per byte.
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+
+	if _, err := mc.w.Write(b); err != nil {
+		return err
+	}
+	_, err := mc.w.Write(body)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,227 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readPacket reads an MQTT packet and returns its first byte and body.
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := readRemainingLength(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// written returns the bytes written by f to a conn.
+func written(t *testing.T, f func(mc *conn) error) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	got := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(server)
+		got <- b
+	}()
+
+	mc := &conn{c: client, w: bufio.NewWriter(client)}
+	if err := f(mc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.flush(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	return <-got
+}
+
+func TestPublishPacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		retain  bool
+		want    []byte
+	}{
+		{
+			name:    "retained",
+			topic:   "a/b",
+			payload: "on",
+			retain:  true,
+			want:    []byte{0x31, 7, 0, 3, 'a', '/', 'b', 'o', 'n'},
+		},
+		{
+			name:    "not retained",
+			topic:   "a",
+			payload: "",
+			want:    []byte{0x30, 3, 0, 1, 'a'},
+		},
+		{
+			// 2 + 1 + 197 = 200 bytes are encoded as 0xc8 0x01.
+			name:    "remaining length of 2 bytes",
+			topic:   "a",
+			payload: strings.Repeat("x", 197),
+			retain:  true,
+			want:    append([]byte{0x31, 0xc8, 0x01, 0, 1, 'a'}, strings.Repeat("x", 197)...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := written(t, func(mc *conn) error {
+				return mc.publish(tt.topic, []byte(tt.payload), tt.retain)
+			})
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("PUBLISH = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingAndDisconnectPackets(t *testing.T) {
+	if got, want := written(t, (*conn).ping), []byte{0xc0, 0}; !bytes.Equal(got, want) {
+		t.Errorf("PINGREQ = % x, want % x", got, want)
+	}
+
+	client, server := net.Pipe()
+	got := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(server)
+		got <- b
+	}()
+	mc := &conn{c: client, w: bufio.NewWriter(client)}
+	mc.close()
+	if b, want := <-got, []byte{0xe0, 0}; !bytes.Equal(b, want) {
+		t.Errorf("DISCONNECT = % x, want % x", b, want)
+	}
+}
+
+func TestConnectPacket(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		will     *message
+		want     []byte
+	}{
+		{
+			name: "no will and no user",
+			want: []byte{
+				0x10, 13,
+				0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60,
+				0, 1, 'c',
+			},
+		},
+		{
+			name:     "retained will and user",
+			username: "u",
+			password: "p",
+			will:     &message{topic: "n/availability", payload: []byte("offline"), retain: true},
+			want: append(append([]byte{
+				0x10, 44,
+				0, 4, 'M', 'Q', 'T', 'T', 4, 0xe6, 0, 60,
+				0, 1, 'c',
+				0, 14}, "n/availability"...),
+				0, 7, 'o', 'f', 'f', 'l', 'i', 'n', 'e',
+				0, 1, 'u',
+				0, 1, 'p',
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			got := make(chan []byte, 1)
+			go func() {
+				r := bufio.NewReader(server)
+				header, body, err := readPacket(r)
+				if err != nil {
+					got <- nil
+					return
+				}
+				got <- append([]byte{header, byte(len(body))}, body...)
+				// CONNACK with return code 0.
+				server.Write([]byte{0x20, 2, 0, 0})
+			}()
+
+			mc := &conn{c: client, w: bufio.NewWriter(client)}
+			if err := mc.connect("c", tt.username, tt.password, tt.will); err != nil {
+				t.Fatal(err)
+			}
+			if b := <-got; !bytes.Equal(b, tt.want) {
+				t.Errorf("CONNECT = % x, want % x", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		readPacket(bufio.NewReader(server))
+		server.Write([]byte{0x20, 2, 0, 5})
+	}()
+
+	mc := &conn{c: client, w: bufio.NewWriter(client)}
+	err := mc.connect("c", "u", "wrong", nil)
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("connect() error = %v, want not authorized", err)
+	}
+}
+
+func TestPublishAfterDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	got := make(chan int)
+	go func() {
+		b, _ := ioutil.ReadAll(server)
+		got <- len(b)
+	}()
+
+	mc := &conn{c: client, w: bufio.NewWriter(client)}
+	// The deadline set by the last flush has expired before the next pass.
+	client.SetWriteDeadline(time.Now().Add(-time.Second))
+
+	// The packets are larger than the buffer, so they are written to the
+	// connection before flush.
+	payload := []byte(strings.Repeat("x", 1000))
+	for i := 0; i < 10; i++ {
+		if err := mc.publish("a", payload, true); err != nil {
+			t.Fatalf("publish() error = %v", err)
+		}
+	}
+	if err := mc.flush(); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	client.Close()
+
+	if n, want := <-got, 10*(3+2+1+1000); n != want {
+		t.Errorf("received %d bytes, want %d", n, want)
+	}
+}
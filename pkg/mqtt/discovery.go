@@ -0,0 +1,99 @@
+package mqtt
+
+// discovery is a config of the Home Assistant MQTT discovery.
+type discovery struct {
+	component string
+	objectID  string
+	config    discoveryConfig
+}
+
+type discoveryConfig struct {
+	Name              string                  `json:"name"`
+	UniqueID          string                  `json:"unique_id"`
+	StateTopic        string                  `json:"state_topic"`
+	Availability      []discoveryAvailability `json:"availability"`
+	AvailabilityMode  string                  `json:"availability_mode"`
+	ValueTemplate     string                  `json:"value_template"`
+	DeviceClass       string                  `json:"device_class,omitempty"`
+	StateClass        string                  `json:"state_class,omitempty"`
+	UnitOfMeasurement string                  `json:"unit_of_measurement,omitempty"`
+	Icon              string                  `json:"icon,omitempty"`
+	PayloadOn         string                  `json:"payload_on,omitempty"`
+	PayloadOff        string                  `json:"payload_off,omitempty"`
+	Device            discoveryDevice         `json:"device"`
+}
+
+type discoveryAvailability struct {
+	Topic string `json:"topic"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discoveries returns the entities of the nasne identified by id.
+func discoveries(prefix, id string, st State) []discovery {
+	device := discoveryDevice{
+		Identifiers:  []string{"nasne_" + id},
+		Name:         st.Name,
+		Manufacturer: "Sony Interactive Entertainment",
+		Model:        "nasne",
+	}
+	if device.Name == "" {
+		device.Name = "nasne " + st.Addr
+	}
+
+	entity := func(component, objectID, name, template string) discovery {
+		return discovery{
+			component: component,
+			objectID:  objectID,
+			config: discoveryConfig{
+				Name:       name,
+				UniqueID:   "nasne_" + id + "_" + objectID,
+				StateTopic: stateTopic(prefix, id),
+				// The entities are available only while both the exporter
+				// and the nasne are.
+				Availability: []discoveryAvailability{
+					{Topic: exporterAvailabilityTopic(prefix)},
+					{Topic: availabilityTopic(prefix, id)},
+				},
+				AvailabilityMode: "all",
+				ValueTemplate:    template,
+				Device:           device,
+			},
+		}
+	}
+
+	recording := entity("binary_sensor", "recording", "Recording", "{{ 'ON' if value_json.recording else 'OFF' }}")
+	recording.config.PayloadOn = "ON"
+	recording.config.PayloadOff = "OFF"
+	recording.config.Icon = "mdi:record-rec"
+
+	tunerStatus := entity("sensor", "tuner_status", "Tuner status", "{{ value_json.tuner_status }}")
+	tunerStatus.config.Icon = "mdi:television-classic"
+
+	diskUsed := entity("sensor", "disk_used", "Disk used", "{{ value_json.disk_used_bytes }}")
+	diskUsed.config.DeviceClass = "data_size"
+	diskUsed.config.StateClass = "measurement"
+	diskUsed.config.UnitOfMeasurement = "B"
+
+	diskUsage := entity("sensor", "disk_usage", "Disk usage", "{{ value_json.disk_usage_percent | round(1) }}")
+	diskUsage.config.StateClass = "measurement"
+	diskUsage.config.UnitOfMeasurement = "%"
+	diskUsage.config.Icon = "mdi:harddisk"
+
+	clients := entity("sensor", "dtcpip_clients", "DTCP-IP clients", "{{ value_json.dtcpip_clients }}")
+	clients.config.StateClass = "measurement"
+	clients.config.Icon = "mdi:cellphone-play"
+
+	nextTitle := entity("sensor", "next_reservation", "Next reservation", "{{ value_json.next_reservation_title }}")
+	nextTitle.config.Icon = "mdi:calendar-clock"
+
+	nextTime := entity("sensor", "next_reservation_time", "Next reservation time", "{{ value_json.next_reservation_time }}")
+	nextTime.config.DeviceClass = "timestamp"
+
+	return []discovery{recording, tunerStatus, diskUsed, diskUsage, clients, nextTitle, nextTime}
+}
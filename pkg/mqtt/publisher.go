@@ -0,0 +1,380 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "nasne"
+
+	labelResult = "result"
+
+	resultSuccess = "success"
+	resultFailure = "failure"
+
+	// connectTimeout is the timeout of connecting to the broker.
+	connectTimeout = 30 * time.Second
+	// reconnectInterval is the interval of retrying to publish after a failure.
+	reconnectInterval = 10 * time.Second
+
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+)
+
+// State is the state of a nasne published to the state topic in JSON.
+type State struct {
+	Addr                 string     `json:"addr"`
+	Name                 string     `json:"name"`
+	Reachable            bool       `json:"reachable"`
+	TunerStatus          int        `json:"tuner_status"`
+	Recording            bool       `json:"recording"`
+	DiskTotalBytes       float64    `json:"disk_total_bytes"`
+	DiskUsedBytes        float64    `json:"disk_used_bytes"`
+	DiskUsagePercent     float64    `json:"disk_usage_percent"`
+	DTCPIPClients        int        `json:"dtcpip_clients"`
+	NextReservationTitle *string    `json:"next_reservation_title"`
+	NextReservationTime  *time.Time `json:"next_reservation_time"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// Config is the configuration of Publisher.
+type Config struct {
+	// URL is the URL of the broker, such as "tcp://192.0.2.1:1883".
+	URL      string
+	ClientID string
+	Username string
+	Password string
+	// TopicPrefix is the prefix of the state and availability topics.
+	TopicPrefix string
+	// DiscoveryPrefix is the discovery prefix of Home Assistant. The discovery
+	// is disabled if it is empty.
+	DiscoveryPrefix string
+}
+
+// Publisher publishes the states of nasnes to retained topics. The state of a
+// nasne merges the responses of its snapshots, because each snapshot may hold
+// only a part of them.
+type Publisher struct {
+	config Config
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	states map[string]*State
+	dirty  map[string]bool
+
+	notify chan struct{}
+
+	publishesCounter *prometheus.CounterVec
+}
+
+// NewPublisher returns a Publisher.
+func NewPublisher(logger *slog.Logger, config Config) *Publisher {
+	return &Publisher{
+		config: config,
+		logger: logger,
+		states: map[string]*State{},
+		dirty:  map[string]bool{},
+		notify: make(chan struct{}, 1),
+
+		publishesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "mqtt_publishes_total",
+				Help:      "Number of attempts to publish the states of nasnes to MQTT.",
+			},
+			[]string{
+				labelResult,
+			},
+		),
+	}
+}
+
+// Collectors returns the metrics to be registered.
+func (p *Publisher) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.publishesCounter}
+}
+
+// Update updates the state of the nasne of s, which is published by Run. It
+// never blocks.
+func (p *Publisher) Update(s *collector.Snapshot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, ok := p.states[s.Addr]
+	if !ok {
+		st = &State{Addr: s.Addr}
+		p.states[s.Addr] = st
+	}
+
+	if s.Name != "" {
+		st.Name = s.Name
+	}
+	st.Reachable = s.Reachable
+	st.UpdatedAt = s.Time
+
+	if s.BoxStatus != nil {
+		st.TunerStatus = int(s.BoxStatus.TuningStatus.Status)
+		st.Recording = s.BoxStatus.TuningStatus.Status == nasneclient.TuningStatusRecording
+	}
+
+	if s.HDD != nil {
+		st.DiskTotalBytes, st.DiskUsedBytes, st.DiskUsagePercent = 0, 0, 0
+		for _, hdd := range s.HDD {
+			if hdd.MountStatus != nasneclient.MountStatusMounted {
+				continue
+			}
+			st.DiskTotalBytes += hdd.TotalVolumeSize
+			st.DiskUsedBytes += hdd.UsedVolumeSize
+		}
+		if st.DiskTotalBytes > 0 {
+			st.DiskUsagePercent = st.DiskUsedBytes / st.DiskTotalBytes * 100
+		}
+	}
+
+	if s.DTCPIPClients != nil {
+		st.DTCPIPClients = s.DTCPIPClients.Number
+	}
+
+	if s.Reserved != nil {
+		st.NextReservationTitle, st.NextReservationTime = nil, nil
+		if item, start := nextReservation(s.Reserved.Item, s.Time); item != nil {
+			title := item.Title
+			st.NextReservationTitle = &title
+			st.NextReservationTime = &start
+		}
+	}
+
+	p.dirty[s.Addr] = true
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// nextReservation returns the reservation which starts first after now.
+func nextReservation(items []*nasneclient.ReservedListItem, now time.Time) (*nasneclient.ReservedListItem, time.Time) {
+	var next *nasneclient.ReservedListItem
+	var nextStart time.Time
+
+	for _, item := range items {
+		if item.EventID == nasneclient.EventIDNotFound {
+			continue
+		}
+		start := item.StartDateTime.Time
+		if start.IsZero() || start.Before(now) {
+			continue
+		}
+		if next == nil || start.Before(nextStart) {
+			next, nextStart = item, start
+		}
+	}
+
+	return next, nextStart
+}
+
+// Run keeps a session with the broker and publishes the updated states until
+// ctx is canceled. The exporter availability topic is set to "online" while
+// connected, and to "offline" by the will of the session if the exporter stops
+// without disconnecting. The session is reconnected if it is lost.
+func (p *Publisher) Run(ctx context.Context) {
+	pingTicker := time.NewTicker(keepAlive / 2)
+	defer pingTicker.Stop()
+
+	var mc *conn
+	defer func() {
+		if mc != nil {
+			p.disconnect(mc)
+		}
+	}()
+
+	var reconnect <-chan time.Time
+	for {
+		var lost <-chan struct{}
+		if mc != nil {
+			lost = mc.done
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-pingTicker.C:
+			if mc != nil {
+				if err := mc.ping(); err != nil {
+					// readLoop notices the closed connection.
+					mc.c.Close()
+				}
+			}
+			continue
+		case <-lost:
+			p.logger.Warn("MQTT connection lost", "url", p.config.URL, "err", mc.err)
+			mc.c.Close()
+			mc = nil
+		case <-p.notify:
+		case <-reconnect:
+		}
+		reconnect = nil
+
+		var n int
+		var err error
+		mc, n, err = p.publishDirty(ctx, mc)
+		if err != nil {
+			p.logger.Error("failed to publish to MQTT", "url", p.config.URL, "err", err)
+			p.publishesCounter.WithLabelValues(resultFailure).Inc()
+			reconnect = time.After(reconnectInterval)
+			continue
+		}
+		if n > 0 {
+			p.logger.Debug("published to MQTT", "url", p.config.URL, "boxes", n)
+			p.publishesCounter.WithLabelValues(resultSuccess).Inc()
+		}
+	}
+}
+
+// publishDirty publishes the updated states with mc, connecting to the broker
+// if mc is nil. It returns the connection and the number of published states.
+// On failure, the connection is closed and the states are kept to publish
+// again.
+func (p *Publisher) publishDirty(ctx context.Context, mc *conn) (*conn, int, error) {
+	if mc == nil {
+		var err error
+		mc, err = p.connect(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	p.mu.Lock()
+	var states []State
+	for addr := range p.dirty {
+		states = append(states, *p.states[addr])
+	}
+	p.dirty = map[string]bool{}
+	p.mu.Unlock()
+
+	err := func() error {
+		for _, st := range states {
+			if err := p.publishState(mc, st); err != nil {
+				return err
+			}
+		}
+		return mc.flush()
+	}()
+	if err != nil {
+		mc.c.Close()
+
+		p.mu.Lock()
+		for _, st := range states {
+			p.dirty[st.Addr] = true
+		}
+		p.mu.Unlock()
+
+		return nil, 0, err
+	}
+
+	return mc, len(states), nil
+}
+
+// connect connects to the broker and publishes that the exporter is online.
+// All states are published again on the new connection, because the broker
+// may have lost the retained messages.
+func (p *Publisher) connect(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	topic := exporterAvailabilityTopic(p.config.TopicPrefix)
+	will := &message{topic: topic, payload: []byte(payloadOffline), retain: true}
+
+	mc, err := dial(ctx, p.config.URL, p.config.ClientID, p.config.Username, p.config.Password, will)
+	if err != nil {
+		return nil, err
+	}
+	if err := mc.publish(topic, []byte(payloadOnline), true); err != nil {
+		mc.c.Close()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	for addr := range p.states {
+		p.dirty[addr] = true
+	}
+	p.mu.Unlock()
+
+	p.logger.Info("connected to MQTT broker", "url", p.config.URL)
+	return mc, nil
+}
+
+// disconnect publishes that the exporter is offline and disconnects, because
+// the broker discards the will on DISCONNECT.
+func (p *Publisher) disconnect(mc *conn) {
+	err := mc.publish(exporterAvailabilityTopic(p.config.TopicPrefix), []byte(payloadOffline), true)
+	if cerr := mc.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		p.logger.Warn("failed to disconnect from MQTT broker", "url", p.config.URL, "err", err)
+	}
+}
+
+func (p *Publisher) publishState(mc *conn, st State) error {
+	id := boxID(st.Addr)
+
+	// The discovery is published every time, because the broker may have lost
+	// the retained messages. Home Assistant ignores the same config.
+	if p.config.DiscoveryPrefix != "" {
+		for _, d := range discoveries(p.config.TopicPrefix, id, st) {
+			b, err := json.Marshal(d.config)
+			if err != nil {
+				return err
+			}
+			topic := p.config.DiscoveryPrefix + "/" + d.component + "/nasne_" + id + "/" + d.objectID + "/config"
+			if err := mc.publish(topic, b, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := mc.publish(stateTopic(p.config.TopicPrefix, id), b, true); err != nil {
+		return err
+	}
+
+	availability := payloadOffline
+	if st.Reachable {
+		availability = payloadOnline
+	}
+	return mc.publish(availabilityTopic(p.config.TopicPrefix, id), []byte(availability), true)
+}
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// boxID returns the ID of the nasne at addr used in topics.
+func boxID(addr string) string {
+	return invalidIDChars.ReplaceAllString(addr, "_")
+}
+
+func stateTopic(prefix, id string) string {
+	return prefix + "/" + id + "/state"
+}
+
+func availabilityTopic(prefix, id string) string {
+	return prefix + "/" + id + "/availability"
+}
+
+// exporterAvailabilityTopic returns the topic of whether the exporter is
+// connected to the broker.
+func exporterAvailabilityTopic(prefix string) string {
+	return prefix + "/availability"
+}
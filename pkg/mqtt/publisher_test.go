@@ -0,0 +1,317 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/collector"
+	"github.com/hatotaka/nasne_exporter/pkg/nasneclient"
+)
+
+// packet is a packet received by the fake broker.
+type packet struct {
+	header byte
+	body   []byte
+}
+
+// topic returns the topic of a PUBLISH packet.
+func (p packet) topic() string {
+	n := int(p.body[0])<<8 | int(p.body[1])
+	return string(p.body[2 : 2+n])
+}
+
+// payload returns the payload of a PUBLISH packet.
+func (p packet) payload() string {
+	n := int(p.body[0])<<8 | int(p.body[1])
+	return string(p.body[2+n:])
+}
+
+// session is a connection accepted by the fake broker.
+type session struct {
+	c       net.Conn
+	packets chan packet
+}
+
+// next returns the next packet of s.
+func (s *session) next(t *testing.T) packet {
+	t.Helper()
+
+	select {
+	case p, ok := <-s.packets:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a packet")
+	}
+	return packet{}
+}
+
+// nextPublish returns the next PUBLISH packet to topic, skipping the others.
+func (s *session) nextPublish(t *testing.T, topic string) packet {
+	t.Helper()
+
+	for {
+		p := s.next(t)
+		if p.header>>4 == packetPublish && p.topic() == topic {
+			return p
+		}
+	}
+}
+
+// newBroker returns the URL of a fake broker which accepts every CONNECT and
+// sends the sessions to the channel.
+func newBroker(t *testing.T) (string, <-chan *session) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan *session, 10)
+	t.Cleanup(func() {
+		l.Close()
+		for {
+			select {
+			case s := <-sessions:
+				s.c.Close()
+			default:
+				return
+			}
+		}
+	})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s := &session{c: c, packets: make(chan packet, 100)}
+			go func() {
+				defer close(s.packets)
+				r := bufio.NewReader(c)
+				for {
+					header, body, err := readPacket(r)
+					if err != nil {
+						return
+					}
+					if header>>4 == packetConnect {
+						c.Write([]byte{0x20, 2, 0, 0})
+					}
+					s.packets <- packet{header: header, body: body}
+				}
+			}()
+			sessions <- s
+		}
+	}()
+
+	return "tcp://" + l.Addr().String(), sessions
+}
+
+func nextSession(t *testing.T, sessions <-chan *session) *session {
+	t.Helper()
+
+	select {
+	case s := <-sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a connection")
+	}
+	return nil
+}
+
+func testSnapshot() *collector.Snapshot {
+	return &collector.Snapshot{
+		Addr:      "192.0.2.1",
+		Name:      "nasne1",
+		Time:      time.Date(2018, 1, 1, 21, 0, 0, 0, time.UTC),
+		Reachable: true,
+		BoxStatus: &nasneclient.BoxStatusList{
+			TuningStatus: nasneclient.BoxStatusListTuningStatus{Status: nasneclient.TuningStatusRecording},
+		},
+		// Number is the number of clients reported by nasne, which may be
+		// different from the length of the list.
+		DTCPIPClients: &nasneclient.DTCPIPClientList{
+			Number: 2,
+			Client: []*nasneclient.DTCPIPClientListClient{{ID: 1}},
+		},
+	}
+}
+
+func TestPublisherSession(t *testing.T) {
+	url, sessions := newBroker(t)
+
+	p := NewPublisher(slog.New(slog.DiscardHandler), Config{
+		URL:             url,
+		ClientID:        "nasne_exporter",
+		TopicPrefix:     "nasne",
+		DiscoveryPrefix: "homeassistant",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	p.Update(testSnapshot())
+	s := nextSession(t, sessions)
+
+	connect := s.next(t)
+	if connect.header != packetConnect<<4 {
+		t.Fatalf("first packet = %#x, want CONNECT", connect.header)
+	}
+	// The will is "offline" retained on the exporter availability topic.
+	wantFlags := byte(connectFlagWill | connectFlagWillRetain | connectFlagCleanSession)
+	if flags := connect.body[7]; flags != wantFlags {
+		t.Errorf("CONNECT flags = %#x, want %#x", flags, wantFlags)
+	}
+	wantPayload := string(appendString(appendString(appendString(nil, "nasne_exporter"), "nasne/availability"), "offline"))
+	if payload := string(connect.body[10:]); payload != wantPayload {
+		t.Errorf("CONNECT payload = %q, want %q", payload, wantPayload)
+	}
+
+	online := s.next(t)
+	if online.header != packetPublish<<4|publishFlagRetain || online.topic() != "nasne/availability" || online.payload() != payloadOnline {
+		t.Errorf("packet after CONNECT = %#x %v %v, want retained online to nasne/availability", online.header, online.topic(), online.payload())
+	}
+
+	config := s.nextPublish(t, "homeassistant/binary_sensor/nasne_192_0_2_1/recording/config")
+	var dc discoveryConfig
+	if err := json.Unmarshal([]byte(config.payload()), &dc); err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.Availability) != 2 || dc.Availability[0].Topic != "nasne/availability" || dc.Availability[1].Topic != "nasne/192_0_2_1/availability" || dc.AvailabilityMode != "all" {
+		t.Errorf("availability = %+v %v, want both availability topics with mode all", dc.Availability, dc.AvailabilityMode)
+	}
+
+	statePacket := s.nextPublish(t, "nasne/192_0_2_1/state")
+	if statePacket.header&publishFlagRetain == 0 {
+		t.Error("state is not retained")
+	}
+	var st State
+	if err := json.Unmarshal([]byte(statePacket.payload()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.Recording || st.TunerStatus != 3 || st.DTCPIPClients != 2 || st.Name != "nasne1" {
+		t.Errorf("state = %+v, want recording nasne1 with 2 DTCP-IP clients", st)
+	}
+
+	if a := s.nextPublish(t, "nasne/192_0_2_1/availability"); a.payload() != payloadOnline {
+		t.Errorf("box availability = %v, want online", a.payload())
+	}
+
+	// The next update is published in the same session.
+	p.Update(testSnapshot())
+	s.nextPublish(t, "nasne/192_0_2_1/state")
+
+	// Stopping publishes offline and disconnects, because the broker does not
+	// publish the will on DISCONNECT.
+	cancel()
+	<-done
+
+	if offline := s.nextPublish(t, "nasne/availability"); offline.payload() != payloadOffline || offline.header&publishFlagRetain == 0 {
+		t.Errorf("availability on stop = %v, want retained offline", offline.payload())
+	}
+	if d := s.next(t); d.header != packetDisconnect<<4 {
+		t.Errorf("last packet = %#x, want DISCONNECT", d.header)
+	}
+
+	select {
+	case s := <-sessions:
+		t.Errorf("unexpected connection %v", s.c.RemoteAddr())
+	default:
+	}
+}
+
+func TestPublisherReconnect(t *testing.T) {
+	url, sessions := newBroker(t)
+
+	p := NewPublisher(slog.New(slog.DiscardHandler), Config{URL: url, TopicPrefix: "nasne"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	p.Update(testSnapshot())
+	s := nextSession(t, sessions)
+	s.nextPublish(t, "nasne/192_0_2_1/state")
+
+	// The broker drops the connection.
+	s.c.Close()
+
+	// The publisher reconnects and publishes the states again without an
+	// update.
+	s = nextSession(t, sessions)
+	if connect := s.next(t); connect.header != packetConnect<<4 {
+		t.Fatalf("first packet = %#x, want CONNECT", connect.header)
+	}
+	if online := s.nextPublish(t, "nasne/availability"); online.payload() != payloadOnline {
+		t.Errorf("availability = %v, want online", online.payload())
+	}
+	s.nextPublish(t, "nasne/192_0_2_1/state")
+}
+
+func TestPublisherManyBoxes(t *testing.T) {
+	url, sessions := newBroker(t)
+
+	p := NewPublisher(slog.New(slog.DiscardHandler), Config{URL: url, TopicPrefix: "nasne", DiscoveryPrefix: "homeassistant"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	addrs := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	update := func() {
+		for _, addr := range addrs {
+			s := testSnapshot()
+			s.Addr = addr
+			p.Update(s)
+		}
+	}
+
+	update()
+	s := nextSession(t, sessions)
+
+	// A pass of the discovery configs and states of the boxes is larger than
+	// the write buffer.
+	var size int
+	available := map[string]bool{}
+	for len(available) < len(addrs) {
+		pk := s.next(t)
+		size += len(pk.body)
+		for _, addr := range addrs {
+			if pk.header>>4 == packetPublish && pk.topic() == availabilityTopic("nasne", boxID(addr)) {
+				available[addr] = true
+			}
+		}
+	}
+	if size <= 4096 {
+		t.Fatalf("published %d bytes, want more than the buffer", size)
+	}
+
+	// The next pass is published in the same session.
+	update()
+	states := map[string]bool{}
+	for len(states) < len(addrs) {
+		pk := s.next(t)
+		for _, addr := range addrs {
+			if pk.header>>4 == packetPublish && pk.topic() == stateTopic("nasne", boxID(addr)) {
+				states[addr] = true
+			}
+		}
+	}
+
+	select {
+	case s := <-sessions:
+		t.Errorf("unexpected connection %v", s.c.RemoteAddr())
+	default:
+	}
+}
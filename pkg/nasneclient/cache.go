@@ -0,0 +1,161 @@
+package nasneclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	labelAddr   = "addr"
+	labelResult = "result"
+
+	cacheHit   = "hit"
+	cacheMiss  = "miss"
+	cacheStale = "stale"
+)
+
+// Cache caches the responses of nasne for each endpoint. Concurrent requests
+// to the same URL are sent to nasne only once. If a request fails, the last
+// good response is served as stale up to maxStale after it has expired.
+type Cache struct {
+	ttls     map[string]time.Duration
+	maxStale time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry
+	inflight map[string]*call
+
+	requestsCounter *prometheus.CounterVec
+	staleGauge      *prometheus.GaugeVec
+}
+
+type cacheEntry struct {
+	body    []byte
+	fetched time.Time
+}
+
+// call is a request to nasne in flight.
+type call struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+// NewCache returns a Cache which caches the responses of the endpoints, such
+// as "recorded/titleListGet", in ttls. The responses of the other endpoints
+// are not cached.
+func NewCache(ttls map[string]time.Duration, maxStale time.Duration) *Cache {
+	return &Cache{
+		ttls:     ttls,
+		maxStale: maxStale,
+		entries:  map[string]*cacheEntry{},
+		inflight: map[string]*call{},
+
+		requestsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "client_cache_requests_total",
+				Help:      "Number of requests to the cache by result.",
+			},
+			[]string{
+				labelEndpoint,
+				labelResult,
+			},
+		),
+		staleGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "client_cache_stale",
+				Help:      "Whether the last response served from the cache was stale.",
+			},
+			[]string{
+				labelAddr,
+				labelEndpoint,
+			},
+		),
+	}
+}
+
+// Collectors returns the metrics to be registered.
+func (c *Cache) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.requestsCounter,
+		c.staleGauge,
+	}
+}
+
+// get returns the response of url from the cache or fetch, and how it was
+// served. If the result is stale, the error of fetch is returned with the
+// stale response.
+func (c *Cache) get(ctx context.Context, addr, endpoint, url string, fetch func() ([]byte, error)) ([]byte, string, error) {
+	ttl, cached := c.ttls[endpoint]
+
+	c.mu.Lock()
+	e := c.entries[url]
+	if cached && e != nil && time.Since(e.fetched) < ttl {
+		c.mu.Unlock()
+		c.observe(addr, endpoint, cacheHit)
+		return e.body, cacheHit, nil
+	}
+	c.mu.Unlock()
+
+	body, err := c.do(ctx, url, fetch)
+	if err == nil {
+		if cached {
+			c.mu.Lock()
+			c.entries[url] = &cacheEntry{body: body, fetched: time.Now()}
+			c.mu.Unlock()
+		}
+		c.observe(addr, endpoint, cacheMiss)
+		return body, cacheMiss, nil
+	}
+
+	if cached && e != nil && time.Since(e.fetched) < ttl+c.maxStale {
+		c.observe(addr, endpoint, cacheStale)
+		return e.body, cacheStale, err
+	}
+
+	c.observe(addr, endpoint, cacheMiss)
+	return nil, cacheMiss, err
+}
+
+// do calls fetch, or waits for the call in flight for url until ctx is
+// canceled.
+func (c *Cache) do(ctx context.Context, url string, fetch func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if cl, ok := c.inflight[url]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+			return cl.body, cl.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	cl := &call{done: make(chan struct{})}
+	c.inflight[url] = cl
+	c.mu.Unlock()
+
+	cl.body, cl.err = fetch()
+
+	c.mu.Lock()
+	delete(c.inflight, url)
+	c.mu.Unlock()
+	close(cl.done)
+
+	return cl.body, cl.err
+}
+
+func (c *Cache) observe(addr, endpoint, result string) {
+	c.requestsCounter.WithLabelValues(endpoint, result).Inc()
+
+	var stale float64
+	if result == cacheStale {
+		stale = 1
+	}
+	c.staleGauge.WithLabelValues(addr, endpoint).Set(stale)
+}
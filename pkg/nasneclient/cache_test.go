@@ -0,0 +1,221 @@
+package nasneclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	cachedEndpoint   = "recorded/titleListGet"
+	uncachedEndpoint = "status/boxStatusListGet"
+)
+
+// cacheRequests returns the number of requests to c of endpoint by result.
+func cacheRequests(t *testing.T, c *Cache, endpoint, result string) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := c.requestsCounter.WithLabelValues(endpoint, result).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// countingFetch returns a fetch which returns body or err, and the number of
+// calls to it.
+func countingFetch(body string, err error) (func() ([]byte, error), *int32) {
+	var calls int32
+	return func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(body), nil
+	}, &calls
+}
+
+func TestCacheHit(t *testing.T) {
+	c := NewCache(map[string]time.Duration{cachedEndpoint: time.Hour}, time.Hour)
+	fetch, calls := countingFetch("body", nil)
+
+	for i, want := range []string{cacheMiss, cacheHit, cacheHit} {
+		body, result, err := c.get(context.Background(), "192.0.2.1", cachedEndpoint, "http://192.0.2.1/a", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "body" || result != want {
+			t.Errorf("request %d = %q, %v, want %q, %v", i, body, result, "body", want)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("fetched %d times, want once", *calls)
+	}
+
+	// Other URLs of the endpoint are cached separately.
+	if _, result, _ := c.get(context.Background(), "192.0.2.1", cachedEndpoint, "http://192.0.2.1/b", fetch); result != cacheMiss {
+		t.Errorf("result of another URL = %v, want %v", result, cacheMiss)
+	}
+
+	if got := cacheRequests(t, c, cachedEndpoint, cacheHit); got != 2 {
+		t.Errorf("hits = %v, want 2", got)
+	}
+	if got := cacheRequests(t, c, cachedEndpoint, cacheMiss); got != 2 {
+		t.Errorf("misses = %v, want 2", got)
+	}
+}
+
+func TestCacheExpired(t *testing.T) {
+	c := NewCache(map[string]time.Duration{cachedEndpoint: time.Minute}, 0)
+	fetch, calls := countingFetch("body", nil)
+
+	c.get(context.Background(), "192.0.2.1", cachedEndpoint, "http://192.0.2.1/a", fetch)
+	c.entries["http://192.0.2.1/a"].fetched = time.Now().Add(-time.Minute)
+
+	if _, result, _ := c.get(context.Background(), "192.0.2.1", cachedEndpoint, "http://192.0.2.1/a", fetch); result != cacheMiss {
+		t.Errorf("result = %v, want %v after the TTL", result, cacheMiss)
+	}
+	if *calls != 2 {
+		t.Errorf("fetched %d times, want twice", *calls)
+	}
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	for _, endpoint := range []string{cachedEndpoint, uncachedEndpoint} {
+		t.Run(endpoint, func(t *testing.T) {
+			c := NewCache(map[string]time.Duration{cachedEndpoint: time.Hour}, 0)
+
+			var calls int32
+			release := make(chan struct{})
+			fetch := func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return []byte("body"), nil
+			}
+
+			const n = 10
+			var started, done sync.WaitGroup
+			started.Add(n)
+			done.Add(n)
+			for i := 0; i < n; i++ {
+				go func() {
+					defer done.Done()
+					started.Done()
+					body, _, err := c.get(context.Background(), "192.0.2.1", endpoint, "http://192.0.2.1/a", fetch)
+					if err != nil || string(body) != "body" {
+						t.Errorf("get() = %q, %v, want the body", body, err)
+					}
+				}()
+			}
+
+			// The callers wait for the request in flight.
+			started.Wait()
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			done.Wait()
+
+			if calls := atomic.LoadInt32(&calls); calls != 1 {
+				t.Errorf("fetched %d times by %d callers, want once", calls, n)
+			}
+		})
+	}
+}
+
+func TestCacheSingleflightCanceled(t *testing.T) {
+	c := NewCache(nil, 0)
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go c.get(context.Background(), "192.0.2.1", uncachedEndpoint, "http://192.0.2.1/a", func() ([]byte, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	// A waiting caller gives up with its context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fetch, calls := countingFetch("body", nil)
+	if _, _, err := c.get(ctx, "192.0.2.1", uncachedEndpoint, "http://192.0.2.1/a", fetch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if *calls != 0 {
+		t.Errorf("fetched %d times, want the request in flight to be shared", *calls)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	errFetch := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		endpoint   string
+		age        time.Duration
+		wantResult string
+		wantBody   string
+	}{
+		{
+			name:       "within max stale",
+			endpoint:   cachedEndpoint,
+			age:        90 * time.Second,
+			wantResult: cacheStale,
+			wantBody:   "old",
+		},
+		{
+			name:       "beyond max stale",
+			endpoint:   cachedEndpoint,
+			age:        3 * time.Minute,
+			wantResult: cacheMiss,
+		},
+		{
+			// The responses of endpoints without TTL are never kept.
+			name:       "endpoint without TTL",
+			endpoint:   uncachedEndpoint,
+			wantResult: cacheMiss,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(map[string]time.Duration{cachedEndpoint: time.Minute}, time.Minute)
+
+			ok, _ := countingFetch("old", nil)
+			if _, _, err := c.get(context.Background(), "192.0.2.1", tt.endpoint, "http://192.0.2.1/a", ok); err != nil {
+				t.Fatal(err)
+			}
+			if e, found := c.entries["http://192.0.2.1/a"]; found {
+				e.fetched = time.Now().Add(-tt.age)
+			} else if tt.endpoint == cachedEndpoint {
+				t.Fatal("the response is not cached")
+			}
+
+			failing, _ := countingFetch("", errFetch)
+			body, result, err := c.get(context.Background(), "192.0.2.1", tt.endpoint, "http://192.0.2.1/a", failing)
+			if !errors.Is(err, errFetch) {
+				t.Errorf("get() error = %v, want %v", err, errFetch)
+			}
+			if result != tt.wantResult || string(body) != tt.wantBody {
+				t.Errorf("get() = %q, %v, want %q, %v", body, result, tt.wantBody, tt.wantResult)
+			}
+
+			m := &dto.Metric{}
+			if err := c.staleGauge.WithLabelValues("192.0.2.1", tt.endpoint).Write(m); err != nil {
+				t.Fatal(err)
+			}
+			var wantStale float64
+			if tt.wantResult == cacheStale {
+				wantStale = 1
+			}
+			if got := m.GetGauge().GetValue(); got != wantStale {
+				t.Errorf("stale gauge = %v, want %v", got, wantStale)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package nasneclient
+
+import "strconv"
+
+// The meanings of most values of the following types are not documented.
+// String returns the number for the values without names.
+
+// TuningStatus is the status of the tuner.
+type TuningStatus int
+
+const (
+	// TuningStatusRecording is the status while nasne is recording.
+	TuningStatusRecording TuningStatus = 3
+)
+
+func (s TuningStatus) String() string {
+	switch s {
+	case TuningStatusRecording:
+		return "recording"
+	default:
+		return strconv.Itoa(int(s))
+	}
+}
+
+// BroadcastingType is the type of broadcasting of a channel.
+type BroadcastingType int
+
+const (
+	BroadcastingTypeTerrestrial BroadcastingType = 1
+	BroadcastingTypeBS          BroadcastingType = 2
+	BroadcastingTypeCS          BroadcastingType = 3
+)
+
+func (t BroadcastingType) String() string {
+	switch t {
+	case BroadcastingTypeTerrestrial:
+		return "terrestrial"
+	case BroadcastingTypeBS:
+		return "bs"
+	case BroadcastingTypeCS:
+		return "cs"
+	default:
+		return strconv.Itoa(int(t))
+	}
+}
+
+// Quality is the quality of a recorded title.
+type Quality int
+
+const (
+	// QualityDR is the quality of the broadcast stream as it is.
+	QualityDR Quality = 1
+	// Quality3x is the quality recorded three times longer than DR.
+	Quality3x Quality = 2
+)
+
+func (q Quality) String() string {
+	switch q {
+	case QualityDR:
+		return "dr"
+	case Quality3x:
+		return "3x"
+	default:
+		return strconv.Itoa(int(q))
+	}
+}
+
+// Purpose is the purpose of a DTCP-IP client.
+type Purpose int
+
+const (
+	// PurposeLive is the purpose of watching a live broadcast.
+	PurposeLive Purpose = 1
+	// PurposePlayback is the purpose of playing back a recorded title.
+	PurposePlayback Purpose = 2
+	// PurposeDownload is the purpose of downloading a recorded title.
+	PurposeDownload Purpose = 3
+)
+
+func (p Purpose) String() string {
+	switch p {
+	case PurposeLive:
+		return "live"
+	case PurposePlayback:
+		return "playback"
+	case PurposeDownload:
+		return "download"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// EncryptType is the type of the encryption of a DTCP-IP client.
+type EncryptType int
+
+const (
+	EncryptTypeNone   EncryptType = 0
+	EncryptTypeDTCPIP EncryptType = 1
+)
+
+func (t EncryptType) String() string {
+	switch t {
+	case EncryptTypeNone:
+		return "none"
+	case EncryptTypeDTCPIP:
+		return "dtcp-ip"
+	default:
+		return strconv.Itoa(int(t))
+	}
+}
+
+// MountStatus is the mount status of an HDD.
+type MountStatus int
+
+const (
+	// MountStatusMounted is the status of an HDD which is mounted and can be
+	// recorded to.
+	MountStatusMounted MountStatus = 1
+)
+
+func (s MountStatus) String() string {
+	switch s {
+	case MountStatusMounted:
+		return "mounted"
+	default:
+		return strconv.Itoa(int(s))
+	}
+}
+
+// InternalFlag tells whether an HDD is the internal one of nasne.
+type InternalFlag int
+
+const (
+	// InternalFlagInternal is the flag of the internal HDD.
+	InternalFlagInternal InternalFlag = 1
+)
+
+func (f InternalFlag) String() string {
+	switch f {
+	case InternalFlagInternal:
+		return "internal"
+	default:
+		return strconv.Itoa(int(f))
+	}
+}
+
+// RegisterFlag tells whether an HDD is registered to nasne.
+type RegisterFlag int
+
+const (
+	// RegisterFlagRegistered is the flag of a registered HDD.
+	RegisterFlagRegistered RegisterFlag = 1
+)
+
+func (f RegisterFlag) String() string {
+	switch f {
+	case RegisterFlagRegistered:
+		return "registered"
+	default:
+		return strconv.Itoa(int(f))
+	}
+}
@@ -0,0 +1,203 @@
+package nasneclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	labelReason = "reason"
+
+	reasonRate        = "rate"
+	reasonConcurrency = "concurrency"
+)
+
+// Limiter limits the requests to each nasne, which gets sluggish under load.
+// While a nasne is recording, its rate is divided by recordingSlowdown.
+type Limiter struct {
+	rate              float64
+	burst             int
+	maxConcurrent     int
+	recordingSlowdown float64
+
+	mu    sync.Mutex
+	boxes map[string]*boxLimiter
+
+	throttledCounter        *prometheus.CounterVec
+	throttledSecondsCounter *prometheus.CounterVec
+	recordingBackoffGauge   *prometheus.GaugeVec
+}
+
+type boxLimiter struct {
+	// sem limits the concurrent requests. It is nil if not limited.
+	sem chan struct{}
+
+	mu        sync.Mutex
+	tokens    float64
+	last      time.Time
+	recording bool
+}
+
+// NewLimiter returns a Limiter which allows rate requests per second with
+// burst, and maxConcurrent concurrent requests to each nasne. rate and
+// maxConcurrent of 0 mean no limit.
+func NewLimiter(rate float64, burst, maxConcurrent int, recordingSlowdown float64) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	if recordingSlowdown < 1 {
+		recordingSlowdown = 1
+	}
+
+	return &Limiter{
+		rate:              rate,
+		burst:             burst,
+		maxConcurrent:     maxConcurrent,
+		recordingSlowdown: recordingSlowdown,
+		boxes:             map[string]*boxLimiter{},
+
+		throttledCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "client_throttled_requests_total",
+				Help:      "Number of requests to nasne delayed by the limiter.",
+			},
+			[]string{
+				labelAddr,
+				labelReason,
+			},
+		),
+		throttledSecondsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "client_throttled_seconds_total",
+				Help:      "Total time requests to nasne were delayed by the limiter.",
+			},
+			[]string{
+				labelAddr,
+			},
+		),
+		recordingBackoffGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "client_recording_backoff",
+				Help:      "Whether the requests to nasne are slowed down because it is recording.",
+			},
+			[]string{
+				labelAddr,
+			},
+		),
+	}
+}
+
+// Collectors returns the metrics to be registered.
+func (l *Limiter) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		l.throttledCounter,
+		l.throttledSecondsCounter,
+		l.recordingBackoffGauge,
+	}
+}
+
+func (l *Limiter) box(addr string) *boxLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.boxes[addr]
+	if !ok {
+		b = &boxLimiter{tokens: float64(l.burst), last: time.Now()}
+		if l.maxConcurrent > 0 {
+			b.sem = make(chan struct{}, l.maxConcurrent)
+		}
+		l.boxes[addr] = b
+	}
+	return b
+}
+
+// setRecording sets whether the nasne at addr is recording.
+func (l *Limiter) setRecording(addr string, recording bool) {
+	b := l.box(addr)
+
+	b.mu.Lock()
+	b.recording = recording
+	b.mu.Unlock()
+
+	var backoff float64
+	if recording && l.recordingSlowdown > 1 {
+		backoff = 1
+	}
+	l.recordingBackoffGauge.WithLabelValues(addr).Set(backoff)
+}
+
+// wait waits until a request to the nasne at addr is allowed. The returned
+// function must be called when the request is done.
+func (l *Limiter) wait(ctx context.Context, addr string) (func(), error) {
+	b := l.box(addr)
+	start := time.Now()
+
+	release := func() {}
+	if b.sem != nil {
+		select {
+		case b.sem <- struct{}{}:
+		default:
+			l.throttledCounter.WithLabelValues(addr, reasonConcurrency).Inc()
+			select {
+			case b.sem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		release = func() { <-b.sem }
+	}
+
+	if d := l.reserve(b); d > 0 {
+		l.throttledCounter.WithLabelValues(addr, reasonRate).Inc()
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	if waited := time.Since(start); waited > time.Millisecond {
+		l.throttledSecondsCounter.WithLabelValues(addr).Add(waited.Seconds())
+	}
+
+	return release, nil
+}
+
+// reserve takes a token from the bucket of b and returns the time to wait
+// until the token is available.
+func (l *Limiter) reserve(b *boxLimiter) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rate := l.rate
+	if b.recording {
+		rate /= l.recordingSlowdown
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
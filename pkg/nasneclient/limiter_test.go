@@ -0,0 +1,164 @@
+package nasneclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// throttled returns the number of requests to addr delayed for reason.
+func throttled(t *testing.T, l *Limiter, addr, reason string) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := l.throttledCounter.WithLabelValues(addr, reason).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// near reports whether d is want or slightly less, for the tokens refilled
+// while the test runs.
+func near(d, want time.Duration) bool {
+	return d <= want && d > want-100*time.Millisecond
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l := NewLimiter(1, 3, 0, 1)
+
+	for i := 0; i < 3; i++ {
+		release, err := l.wait(context.Background(), "192.0.2.1")
+		if err != nil {
+			t.Fatalf("request %d in the burst: %v", i, err)
+		}
+		release()
+	}
+	if got := throttled(t, l, "192.0.2.1", reasonRate); got != 0 {
+		t.Errorf("throttled = %v in the burst, want 0", got)
+	}
+
+	// The burst is used up, and the next token comes in a second.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.wait(ctx, "192.0.2.1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := throttled(t, l, "192.0.2.1", reasonRate); got != 1 {
+		t.Errorf("throttled = %v after the burst, want 1", got)
+	}
+
+	// The buckets of other nasnes are separate.
+	if d := l.reserve(l.box("192.0.2.2")); d != 0 {
+		t.Errorf("reserve() of another nasne = %v, want 0", d)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := NewLimiter(0, 1, 2, 1)
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.wait(context.Background(), "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.wait(ctx, "192.0.2.1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("third wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := throttled(t, l, "192.0.2.1", reasonConcurrency); got != 1 {
+		t.Errorf("throttled = %v, want 1", got)
+	}
+
+	// A request is allowed when another one is done.
+	done := make(chan error)
+	go func() {
+		release, err := l.wait(context.Background(), "192.0.2.1")
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	releases[0]()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait() is not allowed after a request is done")
+	}
+	releases[1]()
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := NewLimiter(0, 0, 0, 0)
+
+	for i := 0; i < 100; i++ {
+		release, err := l.wait(context.Background(), "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+}
+
+func TestLimiterRecordingBackoff(t *testing.T) {
+	l := NewLimiter(1, 1, 0, 4)
+
+	backoff := func(addr string) float64 {
+		m := &dto.Metric{}
+		if err := l.recordingBackoffGauge.WithLabelValues(addr).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	// The wait grows by the slowdown while recording.
+	for _, tt := range []struct {
+		addr      string
+		recording bool
+		want      time.Duration
+	}{
+		{addr: "192.0.2.1", recording: false, want: time.Second},
+		{addr: "192.0.2.2", recording: true, want: 4 * time.Second},
+	} {
+		l.setRecording(tt.addr, tt.recording)
+		b := l.box(tt.addr)
+		if d := l.reserve(b); d != 0 {
+			t.Errorf("first reserve() of %v = %v, want 0", tt.addr, d)
+		}
+		if d := l.reserve(b); !near(d, tt.want) {
+			t.Errorf("second reserve() of %v = %v, want %v", tt.addr, d, tt.want)
+		}
+	}
+	if got := backoff("192.0.2.2"); got != 1 {
+		t.Errorf("backoff = %v while recording, want 1", got)
+	}
+
+	// The rate is reset when the recording finishes.
+	l.setRecording("192.0.2.3", true)
+	l.setRecording("192.0.2.3", false)
+	b := l.box("192.0.2.3")
+	l.reserve(b)
+	if d := l.reserve(b); !near(d, time.Second) {
+		t.Errorf("reserve() after recording = %v, want %v", d, time.Second)
+	}
+	if got := backoff("192.0.2.3"); got != 0 {
+		t.Errorf("backoff = %v after recording, want 0", got)
+	}
+
+	// Without slowdown, recording does not back off.
+	l = NewLimiter(1, 1, 0, 1)
+	l.setRecording("192.0.2.1", true)
+	if got := backoff("192.0.2.1"); got != 0 {
+		t.Errorf("backoff = %v without slowdown, want 0", got)
+	}
+}
@@ -0,0 +1,131 @@
+package nasneclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "nasne"
+
+	labelEndpoint = "endpoint"
+	labelCode     = "code"
+)
+
+type endpointKey struct{}
+
+func withEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+func endpointFromContext(ctx context.Context) string {
+	endpoint, _ := ctx.Value(endpointKey{}).(string)
+	return endpoint
+}
+
+// ClientMetrics instruments the requests of NasneClient.
+type ClientMetrics struct {
+	requestsCounter                 *prometheus.CounterVec
+	requestDurationSecondsHistogram *prometheus.HistogramVec
+	responseSizeBytesHistogram      *prometheus.HistogramVec
+}
+
+func NewClientMetrics() *ClientMetrics {
+	return &ClientMetrics{
+		requestsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "client_requests_total",
+				Help:      "Number of requests to nasne.",
+			},
+			[]string{
+				labelEndpoint,
+				labelCode,
+			},
+		),
+		requestDurationSecondsHistogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "client_request_duration_seconds",
+				Help:      "Latency distributions of requests to nasne.",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{
+				labelEndpoint,
+			},
+		),
+		responseSizeBytesHistogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "client_response_size_bytes",
+				Help:      "Size distributions of responses from nasne.",
+				Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+			},
+			[]string{
+				labelEndpoint,
+			},
+		),
+	}
+}
+
+// Collectors returns the metrics to be registered.
+func (m *ClientMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requestsCounter,
+		m.requestDurationSecondsHistogram,
+		m.responseSizeBytesHistogram,
+	}
+}
+
+// InstrumentRoundTripper wraps next to record the metrics of each request.
+func (m *ClientMetrics) InstrumentRoundTripper(next http.RoundTripper) http.RoundTripper {
+	opt := promhttp.WithLabelFromCtx(labelEndpoint, endpointFromContext)
+
+	rt := m.instrumentResponseSize(next)
+	rt = promhttp.InstrumentRoundTripperDuration(m.requestDurationSecondsHistogram, rt, opt)
+	rt = promhttp.InstrumentRoundTripperCounter(m.requestsCounter, rt, opt)
+
+	return rt
+}
+
+// instrumentResponseSize observes the number of bytes read from the response
+// body when it is closed.
+func (m *ClientMetrics) instrumentResponseSize(next http.RoundTripper) http.RoundTripper {
+	return promhttp.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		res, err := next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Body = &countingBody{
+			ReadCloser: res.Body,
+			observer:   m.responseSizeBytesHistogram.WithLabelValues(endpointFromContext(r.Context())),
+		}
+		return res, nil
+	})
+}
+
+type countingBody struct {
+	io.ReadCloser
+	observer prometheus.Observer
+	n        int
+	closed   bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.observer.Observe(float64(b.n))
+	}
+	return b.ReadCloser.Close()
+}
@@ -1,17 +1,23 @@
 package nasneclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
-	"github.com/golang/glog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const loglevel = 10
+var tracer = otel.Tracer("github.com/hatotaka/nasne_exporter/pkg/nasneclient")
 
 const (
 	portStatus   = 64210
@@ -21,67 +27,79 @@ const (
 
 type NasneClient struct {
 	IPAddr string
+	// HTTPClient is used to send requests to nasne. http.DefaultClient is
+	// used if nil.
+	HTTPClient *http.Client
+	// Cache caches the responses of nasne if not nil.
+	Cache *Cache
+	// Limiter limits the requests to nasne if not nil.
+	Limiter *Limiter
+
+	logger *slog.Logger
 }
 
-func NewNasneClient(ipAddr string) (*NasneClient, error) {
-	return &NasneClient{ipAddr}, nil
+func NewNasneClient(ipAddr string, logger *slog.Logger) (*NasneClient, error) {
+	return &NasneClient{
+		IPAddr: ipAddr,
+		logger: logger.With("box", ipAddr),
+	}, nil
 }
 
-func (nc *NasneClient) GetBoxName() (*BoxName, error) {
+func (nc *NasneClient) GetBoxName(ctx context.Context) (*BoxName, error) {
 	bn := &BoxName{}
-	if err := nc.getJson("status/boxNameGet", portStatus, bn, nil); err != nil {
+	if err := nc.getJson(ctx, "status/boxNameGet", portStatus, bn, nil); err != nil {
 		return nil, err
 	}
 
 	return bn, nil
 }
 
-func (nc *NasneClient) GetSoftwareVersion() (*SoftwareVersion, error) {
+func (nc *NasneClient) GetSoftwareVersion(ctx context.Context) (*SoftwareVersion, error) {
 	sv := &SoftwareVersion{}
-	if err := nc.getJson("status/softwareVersionGet", portStatus, sv, nil); err != nil {
+	if err := nc.getJson(ctx, "status/softwareVersionGet", portStatus, sv, nil); err != nil {
 		return nil, err
 	}
 
 	return sv, nil
 }
 
-func (nc *NasneClient) GetHardwareVersion() (*HardwareVersion, error) {
+func (nc *NasneClient) GetHardwareVersion(ctx context.Context) (*HardwareVersion, error) {
 	hv := &HardwareVersion{}
-	if err := nc.getJson("status/hardwareVersionGet", portStatus, hv, nil); err != nil {
+	if err := nc.getJson(ctx, "status/hardwareVersionGet", portStatus, hv, nil); err != nil {
 		return nil, err
 	}
 
 	return hv, nil
 }
 
-func (nc *NasneClient) GetHDDInfo(id int) (*HDDInfo, error) {
+func (nc *NasneClient) GetHDDInfo(ctx context.Context, id int) (*HDDInfo, error) {
 	hi := &HDDInfo{}
 	param := url.Values{}
 	param.Add("id", strconv.Itoa(id))
-	if err := nc.getJson("status/HDDInfoGet", portStatus, hi, &param); err != nil {
+	if err := nc.getJson(ctx, "status/HDDInfoGet", portStatus, hi, &param); err != nil {
 		return nil, err
 	}
 	return hi, nil
 }
 
-func (nc *NasneClient) GetHDDList() (*HDDList, error) {
+func (nc *NasneClient) GetHDDList(ctx context.Context) (*HDDList, error) {
 	hl := &HDDList{}
-	if err := nc.getJson("status/HDDListGet", portStatus, hl, nil); err != nil {
+	if err := nc.getJson(ctx, "status/HDDListGet", portStatus, hl, nil); err != nil {
 		return nil, err
 	}
 	return hl, nil
 }
 
-func (nc *NasneClient) GetDTCPIPClientList() (*DTCPIPClientList, error) {
+func (nc *NasneClient) GetDTCPIPClientList(ctx context.Context) (*DTCPIPClientList, error) {
 	dl := &DTCPIPClientList{}
-	if err := nc.getJson("status/dtcpipClientListGet", portStatus, dl, nil); err != nil {
+	if err := nc.getJson(ctx, "status/dtcpipClientListGet", portStatus, dl, nil); err != nil {
 		return nil, err
 	}
 
 	return dl, nil
 }
 
-func (nc *NasneClient) GetRecordedTitleList() (*RecordedTitleList, error) {
+func (nc *NasneClient) GetRecordedTitleList(ctx context.Context) (*RecordedTitleList, error) {
 	rtl := &RecordedTitleList{}
 
 	param := url.Values{}
@@ -91,14 +109,14 @@ func (nc *NasneClient) GetRecordedTitleList() (*RecordedTitleList, error) {
 	param.Add("requestedCount", "0")
 	param.Add("sortCriteria", "0")
 
-	if err := nc.getJson("recorded/titleListGet", portRecorded, rtl, &param); err != nil {
+	if err := nc.getJson(ctx, "recorded/titleListGet", portRecorded, rtl, &param); err != nil {
 		return nil, err
 	}
 
 	return rtl, nil
 }
 
-func (nc *NasneClient) GetReservedList() (*ReservedList, error) {
+func (nc *NasneClient) GetReservedList(ctx context.Context) (*ReservedList, error) {
 	rl := &ReservedList{}
 
 	param := url.Values{}
@@ -110,22 +128,48 @@ func (nc *NasneClient) GetReservedList() (*ReservedList, error) {
 	param.Add("withDescriptionLong", "0")
 	param.Add("withUserData", "1")
 
-	if err := nc.getJson("schedule/reservedListGet", portSchedule, rl, &param); err != nil {
+	if err := nc.getJson(ctx, "schedule/reservedListGet", portSchedule, rl, &param); err != nil {
 		return nil, err
 	}
 
 	return rl, nil
 }
 
-func (nc *NasneClient) GetBoxStatusList() (*BoxStatusList, error) {
+func (nc *NasneClient) GetBoxStatusList(ctx context.Context) (*BoxStatusList, error) {
 	bsl := &BoxStatusList{}
-	if err := nc.getJson("status/boxStatusListGet", portStatus, bsl, nil); err != nil {
+	if err := nc.getJson(ctx, "status/boxStatusListGet", portStatus, bsl, nil); err != nil {
 		return nil, err
 	}
+
+	if nc.Limiter != nil {
+		nc.Limiter.setRecording(nc.IPAddr, bsl.TuningStatus.Status == TuningStatusRecording)
+	}
+
 	return bsl, nil
 }
 
-func (nc *NasneClient) getJson(endpoint string, port int, data interface{}, values *url.Values) error {
+// errorcode is included in all responses of nasne.
+type errorcode struct {
+	Errorcode int
+}
+
+func (nc *NasneClient) getJson(ctx context.Context, endpoint string, port int, data interface{}, values *url.Values) (err error) {
+	ctx, span := tracer.Start(ctx, endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("nasne.box", nc.IPAddr),
+			attribute.String("nasne.endpoint", endpoint),
+			attribute.Int("nasne.port", port),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	var query string
 	if values != nil {
 		query = values.Encode()
@@ -133,25 +177,82 @@ func (nc *NasneClient) getJson(endpoint string, port int, data interface{}, valu
 
 	url := fmt.Sprintf("http://%s:%d/%s?%s", nc.IPAddr, port, endpoint, query)
 
-	glog.V(loglevel).Infof("url = %v", url)
+	logger := nc.logger.With("endpoint", endpoint, "port", port)
 
-	res, err := http.Get(url)
-	if err != nil {
-		return err
+	fetch := func() ([]byte, error) {
+		return nc.get(ctx, logger, endpoint, url)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	var body []byte
+	if nc.Cache != nil {
+		var result string
+		body, result, err = nc.Cache.get(ctx, nc.IPAddr, endpoint, url, fetch)
+		span.SetAttributes(attribute.String("nasne.cache", result))
+		if result == cacheStale {
+			logger.Warn("serve stale response", "err", err)
+			err = nil
+		}
+	} else {
+		body, err = fetch()
+	}
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
-	glog.V(loglevel).Infof("json=%v", string(body))
 
 	if err := json.Unmarshal(body, data); err != nil {
+		logger.Debug("invalid response", "body", string(body), "err", err)
 		return err
 	}
 
-	glog.V(loglevel).Infof("data=%+v", data)
-
 	return nil
 }
+
+// get sends a request to url and returns the body of the response.
+func (nc *NasneClient) get(ctx context.Context, logger *slog.Logger, endpoint, url string) ([]byte, error) {
+	if nc.Limiter != nil {
+		release, err := nc.Limiter.wait(ctx, nc.IPAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	start := time.Now()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := nc.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req.WithContext(withEndpoint(ctx, endpoint)))
+	if err != nil {
+		logger.Debug("request failed", "duration", time.Since(start), "err", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ec errorcode
+	json.Unmarshal(body, &ec)
+
+	logger.Debug("request done", "status", res.StatusCode, "size", len(body), "errorcode", ec.Errorcode, "duration", time.Since(start))
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("http.response.status_code", res.StatusCode),
+		attribute.Int("nasne.errorcode", ec.Errorcode),
+	)
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %v", res.StatusCode, endpoint)
+	}
+
+	return body, nil
+}
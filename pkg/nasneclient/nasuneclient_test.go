@@ -0,0 +1,49 @@
+package nasneclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+)
+
+// brokenBody fails to be read and records whether it is closed.
+type brokenBody struct {
+	closed bool
+}
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (b *brokenBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestGetClosesBodyOnReadError(t *testing.T) {
+	body := &brokenBody{}
+
+	nc, err := NewNasneClient("192.0.2.1", slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.HTTPClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: body, Request: r}, nil
+	})}
+
+	if _, err := nc.GetBoxName(context.Background()); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("GetBoxName() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if !body.closed {
+		t.Error("the body is not closed after the read failed")
+	}
+}
@@ -0,0 +1,65 @@
+package nasneclient
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// jst is the time zone of nasne. A fixed zone is used so that the time zone
+// database is not needed.
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Time is a time in the responses of nasne, such as "2018-01-01T21:00:00+09:00".
+// Times without a time zone are in JST. It is zero if the response has an
+// empty or malformed value, so that one broken item does not fail the whole
+// list. Callers skip the items with zero times.
+type Time struct {
+	time.Time
+}
+
+func (t *Time) UnmarshalJSON(b []byte) error {
+	t.Time = time.Time{}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil || s == "" {
+		return nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		parsed, err = time.ParseInLocation("2006-01-02T15:04:05", s, jst)
+		if err != nil {
+			return nil
+		}
+	}
+
+	t.Time = parsed
+	return nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339))
+}
+
+// Duration is a duration in seconds in the responses of nasne.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return err
+	}
+
+	d.Duration = time.Duration(seconds * float64(time.Second))
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(d.Seconds(), 'f', -1, 64)), nil
+}
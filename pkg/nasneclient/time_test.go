@@ -0,0 +1,109 @@
+package nasneclient
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want time.Time
+	}{
+		{
+			name: "with time zone",
+			json: `"2018-01-01T21:00:00+09:00"`,
+			want: time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "UTC",
+			json: `"2018-01-01T12:00:00Z"`,
+			want: time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "without time zone in JST",
+			json: `"2018-01-01T21:00:00"`,
+			want: time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "empty",
+			json: `""`,
+		},
+		{
+			name: "malformed",
+			json: `"2018/01/01 21:00"`,
+		},
+		{
+			name: "null",
+			json: `null`,
+		},
+		{
+			name: "not a string",
+			json: `1514808000`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A non-zero time is overwritten.
+			got := Time{time.Now()}
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("Unmarshal(%s) error = %v", tt.json, err)
+			}
+			if !got.Equal(tt.want) || got.IsZero() != tt.want.IsZero() {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.json, got.Time, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeMarshalJSON(t *testing.T) {
+	tests := []struct {
+		time Time
+		want string
+	}{
+		{Time{time.Date(2018, 1, 1, 21, 0, 0, 0, jst)}, `"2018-01-01T21:00:00+09:00"`},
+		{Time{}, `""`},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("Marshal(%v) = %s, want %s", tt.time.Time, b, tt.want)
+		}
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want time.Duration
+	}{
+		{`1800`, 30 * time.Minute},
+		{`0`, 0},
+		{`1.5`, 1500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		var got Duration
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", tt.json, err)
+		}
+		if got.Duration != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.json, got.Duration, tt.want)
+		}
+
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.json {
+			t.Errorf("Marshal(%v) = %s, want %s", got.Duration, b, tt.json)
+		}
+	}
+}
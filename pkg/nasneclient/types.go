@@ -28,9 +28,9 @@ type HDDInfoHDD struct {
 	UsedVolumeSize  float64
 	SerialNumber    string
 	ID              int
-	InternalFlag    int
-	MountStatus     int
-	RegisterFlag    int
+	InternalFlag    InternalFlag
+	MountStatus     MountStatus
+	RegisterFlag    RegisterFlag
 	Format          string
 	Name            string
 	VendorID        string
@@ -45,9 +45,9 @@ type HDDList struct {
 
 type HDDListHDD struct {
 	ID           int
-	InternalFlag int
-	MountStatus  int
-	RegisterFlag int
+	InternalFlag InternalFlag
+	MountStatus  MountStatus
+	RegisterFlag RegisterFlag
 }
 
 type DTCPIPClientList struct {
@@ -61,14 +61,14 @@ type DTCPIPClientListClient struct {
 	MacAddr     string
 	IpAddr      string
 	Name        string
-	Purpose     int
+	Purpose     Purpose
 	LiveInfo    *LiveInfo
 	Content     *Content
-	EncryptType int
+	EncryptType EncryptType
 }
 
 type LiveInfo struct {
-	BroadcastingType int
+	BroadcastingType BroadcastingType
 	ServiceID        int
 }
 
@@ -87,13 +87,13 @@ type RecordedTitleListItem struct {
 	ID               string
 	Title            string
 	Description      string
-	StartDateTime    string
-	Duration         int
+	StartDateTime    Time
+	Duration         Duration
 	ConditionID      string
-	Quality          int
+	Quality          Quality
 	ChannelName      string
 	ChannelNumber    int
-	BroadcastingType int
+	BroadcastingType BroadcastingType
 	ServiceID        int
 	EventID          int
 }
@@ -109,7 +109,11 @@ type ReservedList struct {
 type ReservedListItem struct {
 	ID          string
 	Title       string
-	Descritpion string
+	Description string
+
+	StartDateTime Time
+	Duration      Duration
+	ChannelName   string
 
 	ConflictID int
 	EventID    int
@@ -130,7 +134,7 @@ type BoxStatusList struct {
 }
 
 type BoxStatusListTuningStatus struct {
-	Status            int
+	Status            TuningStatus
 	NetworkId         int
 	TransportStreamId int
 	ServiceId         int
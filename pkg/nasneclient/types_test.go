@@ -0,0 +1,294 @@
+package nasneclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// No captured responses of nasne are available, so the payloads are written
+// by hand after the fields the client decodes, including the empty
+// and malformed times reported on interrupted recordings.
+
+const recordedTitleListPayload = `{
+	"errorcode": 0,
+	"item": [
+		{
+			"id": "1f5e7a.ts",
+			"title": "ニュース",
+			"description": "最新のニュース",
+			"startDateTime": "2018-01-01T21:00:00+09:00",
+			"duration": 1800,
+			"conditionId": "",
+			"quality": 1,
+			"channelName": "ＮＨＫ総合・東京",
+			"channelNumber": 11,
+			"broadcastingType": 1,
+			"serviceId": 1024,
+			"eventId": 4660
+		},
+		{
+			"id": "2a6b0c.ts",
+			"title": "映画",
+			"description": "",
+			"startDateTime": "2018-01-02T19:00:00",
+			"duration": 7200,
+			"conditionId": "c1",
+			"quality": 2,
+			"channelName": "ＢＳ１",
+			"channelNumber": 101,
+			"broadcastingType": 2,
+			"serviceId": 101,
+			"eventId": 8738
+		},
+		{
+			"id": "3c7d1e.ts",
+			"title": "録画中断",
+			"description": "",
+			"startDateTime": "",
+			"duration": 0,
+			"conditionId": "",
+			"quality": 1,
+			"channelName": "",
+			"channelNumber": 0,
+			"broadcastingType": 3,
+			"serviceId": 0,
+			"eventId": 0
+		},
+		{
+			"id": "4d8e2f.ts",
+			"title": "不明",
+			"description": "",
+			"startDateTime": "0000-00-00T00:00:00",
+			"duration": 60,
+			"conditionId": "",
+			"quality": 1,
+			"channelName": "",
+			"channelNumber": 0,
+			"broadcastingType": 7,
+			"serviceId": 0,
+			"eventId": 0
+		}
+	],
+	"totalMatches": 4,
+	"numberReturned": 4
+}`
+
+const reservedListPayload = `{
+	"errorcode": 0,
+	"item": [
+		{
+			"id": "r1",
+			"title": "ドラマ",
+			"description": "第1話",
+			"startDateTime": "2018-01-03T21:00:00+09:00",
+			"duration": 3600,
+			"channelName": "ＮＨＫ総合・東京",
+			"conflictId": 0,
+			"eventId": 4661
+		},
+		{
+			"id": "r2",
+			"title": "終了した番組",
+			"description": "",
+			"startDateTime": "",
+			"duration": 0,
+			"channelName": "",
+			"conflictId": 2,
+			"eventId": 65536
+		}
+	],
+	"totalMatches": 2,
+	"numberReturned": 2
+}`
+
+const dtcpipClientListPayload = `{
+	"errorcode": 0,
+	"number": 2,
+	"client": [
+		{
+			"id": 1,
+			"macAddr": "00:00:5e:00:53:01",
+			"ipAddr": "192.0.2.10",
+			"name": "PS4",
+			"purpose": 1,
+			"liveInfo": {"broadcastingType": 1, "serviceId": 1024},
+			"encryptType": 1
+		},
+		{
+			"id": 2,
+			"macAddr": "00:00:5e:00:53:02",
+			"ipAddr": "192.0.2.11",
+			"name": "torne mobile",
+			"purpose": 3,
+			"content": {"id": "1f5e7a.ts"},
+			"encryptType": 1
+		}
+	]
+}`
+
+const hddListPayload = `{
+	"errorcode": 0,
+	"number": 2,
+	"HDD": [
+		{"id": 0, "internalFlag": 0, "mountStatus": 1, "registerFlag": 1},
+		{"id": 1, "internalFlag": 1, "mountStatus": 0, "registerFlag": 1}
+	]
+}`
+
+func TestRecordedTitleList(t *testing.T) {
+	var l RecordedTitleList
+	if err := json.Unmarshal([]byte(recordedTitleListPayload), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start            time.Time
+		duration         time.Duration
+		quality          string
+		broadcastingType string
+	}{
+		{
+			start:            time.Date(2018, 1, 1, 21, 0, 0, 0, jst),
+			duration:         30 * time.Minute,
+			quality:          "dr",
+			broadcastingType: "terrestrial",
+		},
+		{
+			// Times without a time zone are in JST.
+			start:            time.Date(2018, 1, 2, 19, 0, 0, 0, jst),
+			duration:         2 * time.Hour,
+			quality:          "3x",
+			broadcastingType: "bs",
+		},
+		{
+			// The empty time does not fail the list.
+			quality:          "dr",
+			broadcastingType: "cs",
+		},
+		{
+			// The malformed time does not fail the list.
+			duration:         time.Minute,
+			quality:          "dr",
+			broadcastingType: "7",
+		},
+	}
+
+	if len(l.Item) != len(tests) {
+		t.Fatalf("got %d items, want %d", len(l.Item), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(l.Item[i].ID, func(t *testing.T) {
+			item := l.Item[i]
+			if !item.StartDateTime.Equal(tt.start) || item.StartDateTime.IsZero() != tt.start.IsZero() {
+				t.Errorf("StartDateTime = %v, want %v", item.StartDateTime.Time, tt.start)
+			}
+			if item.Duration.Duration != tt.duration {
+				t.Errorf("Duration = %v, want %v", item.Duration.Duration, tt.duration)
+			}
+			if got := item.Quality.String(); got != tt.quality {
+				t.Errorf("Quality = %v, want %v", got, tt.quality)
+			}
+			if got := item.BroadcastingType.String(); got != tt.broadcastingType {
+				t.Errorf("BroadcastingType = %v, want %v", got, tt.broadcastingType)
+			}
+		})
+	}
+}
+
+func TestReservedList(t *testing.T) {
+	var l ReservedList
+	if err := json.Unmarshal([]byte(reservedListPayload), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.Item) != 2 {
+		t.Fatalf("got %d items, want 2", len(l.Item))
+	}
+	if got, want := l.Item[0].StartDateTime.Time, time.Date(2018, 1, 3, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("StartDateTime = %v, want %v", got, want)
+	}
+	if got := l.Item[0].Duration.Duration; got != time.Hour {
+		t.Errorf("Duration = %v, want 1h", got)
+	}
+	if !l.Item[1].StartDateTime.IsZero() || l.Item[1].EventID != EventIDNotFound || l.Item[1].ConflictID != ConflictIDConflictNG {
+		t.Errorf("item = %+v, want a zero time, not found and conflicted", l.Item[1])
+	}
+}
+
+func TestDTCPIPClientList(t *testing.T) {
+	var l DTCPIPClientList
+	if err := json.Unmarshal([]byte(dtcpipClientListPayload), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Number != 2 || len(l.Client) != 2 {
+		t.Fatalf("got %d of %d clients, want 2 of 2", len(l.Client), l.Number)
+	}
+
+	live := l.Client[0]
+	if live.Purpose != PurposeLive || live.EncryptType != EncryptTypeDTCPIP || live.LiveInfo == nil || live.LiveInfo.BroadcastingType != BroadcastingTypeTerrestrial {
+		t.Errorf("client = %+v, want live terrestrial with DTCP-IP", live)
+	}
+	download := l.Client[1]
+	if download.Purpose != PurposeDownload || download.Content == nil || download.Content.ID != "1f5e7a.ts" {
+		t.Errorf("client = %+v, want download of 1f5e7a.ts", download)
+	}
+}
+
+func TestHDDList(t *testing.T) {
+	var l HDDList
+	if err := json.Unmarshal([]byte(hddListPayload), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HDDListHDD{
+		{ID: 0, MountStatus: MountStatusMounted, RegisterFlag: RegisterFlagRegistered},
+		{ID: 1, InternalFlag: InternalFlagInternal, RegisterFlag: RegisterFlagRegistered},
+	}
+	if len(l.HDD) != len(want) {
+		t.Fatalf("got %d HDDs, want %d", len(l.HDD), len(want))
+	}
+	for i, hdd := range l.HDD {
+		if *hdd != want[i] {
+			t.Errorf("HDD %d = %+v, want %+v", hdd.ID, *hdd, want[i])
+		}
+	}
+}
+
+func TestEnumString(t *testing.T) {
+	tests := []struct {
+		value fmt.Stringer
+		want  string
+	}{
+		{TuningStatusRecording, "recording"},
+		{TuningStatus(0), "0"},
+		{BroadcastingTypeTerrestrial, "terrestrial"},
+		{BroadcastingTypeBS, "bs"},
+		{BroadcastingTypeCS, "cs"},
+		{BroadcastingType(0), "0"},
+		{QualityDR, "dr"},
+		{Quality3x, "3x"},
+		{Quality(9), "9"},
+		{PurposeLive, "live"},
+		{PurposePlayback, "playback"},
+		{PurposeDownload, "download"},
+		{Purpose(0), "0"},
+		{EncryptTypeNone, "none"},
+		{EncryptTypeDTCPIP, "dtcp-ip"},
+		{EncryptType(2), "2"},
+		{MountStatusMounted, "mounted"},
+		{MountStatus(0), "0"},
+		{InternalFlagInternal, "internal"},
+		{InternalFlag(0), "0"},
+		{RegisterFlagRegistered, "registered"},
+		{RegisterFlag(0), "0"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.value.String(); got != tt.want {
+			t.Errorf("%T(%d).String() = %q, want %q", tt.value, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/retry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// graphiteTimeout is the timeout of a connection to send a batch.
+const graphiteTimeout = 30 * time.Second
+
+type Graphite struct {
+	addr      string
+	prefix    string
+	tags      TagMapping
+	retry     int
+	batchSize int
+	logger    *slog.Logger
+}
+
+// NewGraphite returns a Pusher which sends metrics to Graphite at addr, such
+// as "192.0.2.1:2003", in the plaintext protocol. Labels are sent as the tags
+// of Graphite mapped by tags, and names are prefixed with prefix.
+func NewGraphite(logger *slog.Logger, addr, prefix string, tags TagMapping, retry, batchSize int) *Graphite {
+	return &Graphite{
+		addr:      addr,
+		prefix:    prefix,
+		tags:      tags,
+		retry:     retry,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+func (g *Graphite) Push(ctx context.Context, gatherer prometheus.Gatherer) error {
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, s := range toSamples(mfs, time.Now(), g.tags) {
+		lines = append(lines, toGraphiteLine(g.prefix, s))
+	}
+
+	for _, batch := range batches(lines, g.batchSize) {
+		body := []byte(strings.Join(batch, "\n") + "\n")
+
+		g.logger.Debug("send to Graphite", "lines", len(batch), "addr", g.addr)
+
+		err := retry.Do(ctx, g.logger, g.retry, func() error {
+			return g.send(ctx, body)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *Graphite) send(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, graphiteTimeout)
+	defer cancel()
+
+	d := &net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", g.addr)
+	if err != nil {
+		return retry.Recoverable(err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(body); err != nil {
+		return retry.Recoverable(err)
+	}
+	return nil
+}
+
+// graphiteEscaper replaces the characters which are not allowed in the names
+// and tags of Graphite.
+var graphiteEscaper = strings.NewReplacer(";", "_", "~", "_", "=", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+// toGraphiteLine returns s in the plaintext protocol with tags, such as
+// "name;tag=value 1 1600000000".
+func toGraphiteLine(prefix string, s *sample) string {
+	var b strings.Builder
+
+	b.WriteString(graphiteEscaper.Replace(prefix + s.name))
+	for _, t := range s.tags {
+		b.WriteByte(';')
+		b.WriteString(graphiteEscaper.Replace(t.Name))
+		b.WriteByte('=')
+		b.WriteString(graphiteEscaper.Replace(t.Value))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(s.timestamp/1000, 10))
+
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestToGraphiteLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		sample *sample
+		want   string
+	}{
+		{
+			name:   "plain",
+			prefix: "home.",
+			sample: &sample{
+				name:      "nasne_hdd_usage_bytes",
+				tags:      []*Label{{Name: "id", Value: "0"}, {Name: "name", Value: "nasne1"}},
+				value:     1024,
+				timestamp: 1514808000123,
+			},
+			want: "home.nasne_hdd_usage_bytes;id=0;name=nasne1 1024 1514808000",
+		},
+		{
+			name: "separators",
+			sample: &sample{
+				name:      "a b",
+				tags:      []*Label{{Name: "t;1", Value: "living room=~2"}},
+				value:     0.5,
+				timestamp: 1000,
+			},
+			want: "a_b;t_1=living_room__2 0.5 1",
+		},
+		{
+			name: "newline",
+			sample: &sample{
+				name:      "a",
+				tags:      []*Label{{Name: "title", Value: "line1\r\nline2\tx"}},
+				value:     1,
+				timestamp: 1000,
+			},
+			want: "a;title=line1__line2_x 1 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toGraphiteLine(tt.prefix, tt.sample); got != tt.want {
+				t.Errorf("toGraphiteLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphitePush(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Each batch is sent in its own connection.
+	received := make(chan []string, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var lines []string
+			s := bufio.NewScanner(c)
+			for s.Scan() {
+				lines = append(lines, s.Text())
+			}
+			c.Close()
+			received <- lines
+		}
+	}()
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "nasne_hdd_usage_bytes", Help: "h"}, []string{"name", "id"})
+	g.WithLabelValues("nasne 1", "0").Set(1)
+	g.WithLabelValues("nasne 1", "1").Set(2)
+	g.WithLabelValues("nasne 1", "2").Set(3)
+	r.MustRegister(g)
+
+	p := NewGraphite(slog.New(slog.DiscardHandler), l.Addr().String(), "home.", TagMapping{"name": "box"}, 0, 2)
+	if err := p.Push(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for i := 0; i < 2; i++ {
+		batch := <-received
+		if len(batch) > 2 {
+			t.Errorf("batch of %d lines, want up to 2", len(batch))
+		}
+		for _, line := range batch {
+			// Drop the timestamp.
+			lines = append(lines, line[:len(line)-len(" 1514808000")])
+		}
+	}
+	sort.Strings(lines)
+
+	want := []string{
+		"home.nasne_hdd_usage_bytes;box=nasne_1;id=0 1",
+		"home.nasne_hdd_usage_bytes;box=nasne_1;id=1 2",
+		"home.nasne_hdd_usage_bytes;box=nasne_1;id=2 3",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line = %q, want %q", lines[i], want[i])
+		}
+	}
+}
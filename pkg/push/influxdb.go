@@ -0,0 +1,117 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hatotaka/nasne_exporter/pkg/retry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type InfluxDB struct {
+	url       string
+	token     string
+	tags      TagMapping
+	retry     int
+	batchSize int
+	client    *http.Client
+	logger    *slog.Logger
+}
+
+// NewInfluxDB returns a Pusher which writes metrics to the write API of
+// InfluxDB at url in the line protocol, such as
+// "http://192.0.2.1:8086/api/v2/write?org=home&bucket=nasne". Labels are
+// written as tags mapped by tags, and values as the field "value". If token is
+// not empty, it is sent as the API token.
+func NewInfluxDB(logger *slog.Logger, url, token string, tags TagMapping, retry, batchSize int) *InfluxDB {
+	return &InfluxDB{
+		url:       url,
+		token:     token,
+		tags:      tags,
+		retry:     retry,
+		batchSize: batchSize,
+		client:    &http.Client{Timeout: requestTimeout},
+		logger:    logger,
+	}
+}
+
+func (i *InfluxDB) Push(ctx context.Context, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, s := range toSamples(mfs, time.Now(), i.tags) {
+		lines = append(lines, toInfluxLine(s))
+	}
+
+	for _, batch := range batches(lines, i.batchSize) {
+		body := []byte(strings.Join(batch, "\n") + "\n")
+
+		i.logger.Debug("write to InfluxDB", "lines", len(batch), "url", i.url)
+
+		err := retry.Do(ctx, i.logger, i.retry, func() error {
+			return i.post(ctx, body)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *InfluxDB) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, i.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+
+	res, err := i.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return retry.Recoverable(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	return retry.CheckResponseStatus(i.url, res.StatusCode)
+}
+
+// Backslashes are escaped so that a trailing one does not escape the
+// separator, and newlines so that they do not end the line in the middle.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// toInfluxLine returns s in the line protocol with the timestamp in
+// nanoseconds.
+func toInfluxLine(s *sample) string {
+	var b strings.Builder
+
+	b.WriteString(influxMeasurementEscaper.Replace(s.name))
+	for _, t := range s.tags {
+		b.WriteByte(',')
+		b.WriteString(influxTagEscaper.Replace(t.Name))
+		b.WriteByte('=')
+		b.WriteString(influxTagEscaper.Replace(t.Value))
+	}
+	b.WriteString(" value=")
+	b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(s.timestamp*int64(time.Millisecond), 10))
+
+	return b.String()
+}
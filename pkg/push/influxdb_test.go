@@ -0,0 +1,151 @@
+package push
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestToInfluxLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample *sample
+		want   string
+	}{
+		{
+			name: "plain",
+			sample: &sample{
+				name:      "nasne_hdd_usage_bytes",
+				tags:      []*Label{{Name: "id", Value: "0"}, {Name: "name", Value: "nasne1"}},
+				value:     1024,
+				timestamp: 1514808000000,
+			},
+			want: "nasne_hdd_usage_bytes,id=0,name=nasne1 value=1024 1514808000000000000",
+		},
+		{
+			name: "separators",
+			sample: &sample{
+				name:      "a b,c",
+				tags:      []*Label{{Name: "t=1", Value: "living room,2=x"}},
+				value:     0.5,
+				timestamp: 1000,
+			},
+			want: `a\ b\,c,t\=1=living\ room\,2\=x value=0.5 1000000000`,
+		},
+		{
+			// A trailing backslash would escape the following separator.
+			name: "backslash",
+			sample: &sample{
+				name:      `a\`,
+				tags:      []*Label{{Name: "name", Value: `C:\`}},
+				value:     1,
+				timestamp: 1000,
+			},
+			want: `a\\,name=C:\\ value=1 1000000000`,
+		},
+		{
+			// A newline would end the line in the middle.
+			name: "newline",
+			sample: &sample{
+				name:      "a",
+				tags:      []*Label{{Name: "title", Value: "line1\nline2"}},
+				value:     1,
+				timestamp: 1000,
+			},
+			want: `a,title=line1\nline2 value=1 1000000000`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toInfluxLine(tt.sample)
+			if got != tt.want {
+				t.Errorf("toInfluxLine() = %q, want %q", got, tt.want)
+			}
+			if strings.Contains(got, "\n") {
+				t.Errorf("toInfluxLine() = %q contains a newline", got)
+			}
+		})
+	}
+}
+
+// influxRequest is a request received by influxServer.
+type influxRequest struct {
+	header http.Header
+	lines  []string
+}
+
+type influxServer struct {
+	mu       sync.Mutex
+	requests []influxRequest
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, influxRequest{
+		header: r.Header,
+		lines:  strings.Split(strings.TrimSuffix(string(body), "\n"), "\n"),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestInfluxDBPush(t *testing.T) {
+	s := &influxServer{}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "nasne_hdd_usage_bytes", Help: "h"}, []string{"name", "id"})
+	g.WithLabelValues("nasne\n1", "0").Set(1)
+	g.WithLabelValues("nasne\n1", "1").Set(2)
+	g.WithLabelValues("nasne\n1", "2").Set(3)
+	r.MustRegister(g)
+
+	i := NewInfluxDB(slog.New(slog.DiscardHandler), srv.URL, "token", TagMapping{"name": "box", "id": ""}, 0, 2)
+	if err := i.Push(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.requests) != 2 {
+		t.Fatalf("received %d requests, want 2 batches", len(s.requests))
+	}
+	var lines []string
+	for _, req := range s.requests {
+		if got := req.header.Get("Authorization"); got != "Token token" {
+			t.Errorf("Authorization = %q, want %q", got, "Token token")
+		}
+		lines = append(lines, req.lines...)
+	}
+
+	// The id tag is dropped by the mapping, and the name tag is renamed.
+	var values []string
+	for _, l := range lines {
+		fields := strings.Split(l, " ")
+		if len(fields) != 3 || fields[0] != `nasne_hdd_usage_bytes,box=nasne\n1` {
+			t.Errorf("unexpected line %q", l)
+			continue
+		}
+		values = append(values, fields[1])
+		// Timestamps are in nanoseconds.
+		if ns, err := strconv.ParseInt(fields[2], 10, 64); err != nil || time.Since(time.Unix(0, ns)) > time.Minute {
+			t.Errorf("invalid timestamp in %q", l)
+		}
+	}
+	sort.Strings(values)
+	if want := []string{"value=1", "value=2", "value=3"}; strings.Join(values, " ") != strings.Join(want, " ") {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
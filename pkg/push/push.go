@@ -0,0 +1,67 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestTimeout is the timeout of an HTTP request to a receiver.
+const requestTimeout = 10 * time.Second
+
+// Pusher pushes metrics gathered from a prometheus.Gatherer to somewhere.
+type Pusher interface {
+	Push(ctx context.Context, g prometheus.Gatherer) error
+}
+
+// Queue runs a Pusher on its own goroutine, so that a slow receiver does not
+// block the collection.
+type Queue struct {
+	pusher  Pusher
+	g       prometheus.Gatherer
+	timeout time.Duration
+	logger  *slog.Logger
+
+	notify chan struct{}
+}
+
+// NewQueue returns a Queue which pushes the metrics of g with p. Each push is
+// canceled after timeout, which should not be longer than the interval of
+// collection.
+func NewQueue(logger *slog.Logger, p Pusher, g prometheus.Gatherer, timeout time.Duration) *Queue {
+	return &Queue{
+		pusher:  p,
+		g:       g,
+		timeout: timeout,
+		logger:  logger,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Notify requests a push by Run. It never blocks. Requests while a push is
+// running are merged into one, because each push gathers the latest metrics.
+func (q *Queue) Notify() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run pushes the metrics on each request until ctx is canceled.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.notify:
+		}
+
+		pushCtx, cancel := context.WithTimeout(ctx, q.timeout)
+		if err := q.pusher.Push(pushCtx, q.g); err != nil {
+			q.logger.Error("push failed", "err", err)
+		}
+		cancel()
+	}
+}
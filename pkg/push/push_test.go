@@ -0,0 +1,54 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// blockingPusher is a Pusher which blocks until the context is done, like a
+// receiver which never responds.
+type blockingPusher struct {
+	pushed chan error
+}
+
+func (b *blockingPusher) Push(ctx context.Context, g prometheus.Gatherer) error {
+	<-ctx.Done()
+	b.pushed <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestQueue(t *testing.T) {
+	b := &blockingPusher{pushed: make(chan error, 10)}
+	q := NewQueue(slog.New(slog.DiscardHandler), b, prometheus.NewRegistry(), 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	// Notify never blocks while a push is running.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			q.Notify()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Notify blocked")
+	}
+
+	select {
+	case err := <-b.pushed:
+		if err != context.DeadlineExceeded {
+			t.Errorf("push ended with %v, want the deadline exceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("push was not canceled after the timeout")
+	}
+}
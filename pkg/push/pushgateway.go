@@ -0,0 +1,136 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hatotaka/nasne_exporter/pkg/retry"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// groupingLabel is the label used as the grouping key of Pushgateway.
+const groupingLabel = "name"
+
+type Pushgateway struct {
+	url    string
+	job    string
+	retry  int
+	client *http.Client
+	logger *slog.Logger
+}
+
+// NewPushgateway returns a Pusher which pushes metrics to the Pushgateway at
+// url. Metrics are grouped by the name of nasne.
+func NewPushgateway(logger *slog.Logger, url, job string, retry int) *Pushgateway {
+	return &Pushgateway{
+		url:    strings.TrimSuffix(url, "/"),
+		job:    job,
+		retry:  retry,
+		client: &http.Client{Timeout: requestTimeout},
+		logger: logger,
+	}
+}
+
+func (p *Pushgateway) Push(ctx context.Context, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for name, group := range groupByLabel(mfs, groupingLabel) {
+		u := p.url + "/metrics/job/" + url.PathEscape(p.job)
+		if name != "" {
+			u += "/" + groupingKey(groupingLabel, name)
+		}
+
+		buf := &bytes.Buffer{}
+		for _, mf := range group {
+			if _, err := expfmt.MetricFamilyToText(buf, mf); err != nil {
+				return err
+			}
+		}
+
+		p.logger.Debug("push metrics", "url", u)
+
+		err := retry.Do(ctx, p.logger, p.retry, func() error {
+			return p.put(ctx, u, buf.Bytes())
+		})
+		if err != nil {
+			p.logger.Error("push failed", "url", u, "err", err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func (p *Pushgateway) put(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+
+	res, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return retry.Recoverable(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	return retry.CheckResponseStatus(url, res.StatusCode)
+}
+
+// groupingKey returns the path segment of the grouping key. Values containing
+// "/" are encoded in base64 as Pushgateway requires.
+func groupingKey(label, value string) string {
+	if strings.Contains(value, "/") {
+		return label + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return label + "/" + url.PathEscape(value)
+}
+
+// groupByLabel splits metric families by the value of label. Metrics without
+// the label are grouped with the empty string.
+func groupByLabel(mfs []*dto.MetricFamily, label string) map[string][]*dto.MetricFamily {
+	groups := map[string][]*dto.MetricFamily{}
+
+	for _, mf := range mfs {
+		metrics := map[string][]*dto.Metric{}
+		var values []string
+		for _, m := range mf.Metric {
+			var value string
+			for _, lp := range m.Label {
+				if lp.GetName() == label {
+					value = lp.GetValue()
+					break
+				}
+			}
+			if _, ok := metrics[value]; !ok {
+				values = append(values, value)
+			}
+			metrics[value] = append(metrics[value], m)
+		}
+
+		for _, value := range values {
+			groups[value] = append(groups[value], &dto.MetricFamily{
+				Name:   mf.Name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Metric: metrics[value],
+			})
+		}
+	}
+
+	return groups
+}
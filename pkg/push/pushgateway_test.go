@@ -0,0 +1,143 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// pushRequest is a request received by gateway.
+type pushRequest struct {
+	method   string
+	path     string
+	families []string
+}
+
+// gateway is a Pushgateway which responds with the next status of statuses,
+// or 200 if none is left.
+type gateway struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	statuses []int
+	requests []pushRequest
+}
+
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var p expfmt.TextParser
+	mfs, err := p.TextToMetricFamilies(r.Body)
+	if err != nil {
+		gw.t.Errorf("invalid body: %v", err)
+	}
+	var families []string
+	for name := range mfs {
+		families = append(families, name)
+	}
+	sort.Strings(families)
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	gw.requests = append(gw.requests, pushRequest{method: r.Method, path: r.URL.EscapedPath(), families: families})
+
+	status := http.StatusOK
+	if len(gw.statuses) > 0 {
+		status, gw.statuses = gw.statuses[0], gw.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newGateway(t *testing.T, statuses ...int) (*gateway, string) {
+	gw := &gateway{t: t, statuses: statuses}
+	srv := httptest.NewServer(gw)
+	t.Cleanup(srv.Close)
+
+	return gw, srv.URL
+}
+
+func TestPushgatewayPush(t *testing.T) {
+	gw, url := newGateway(t)
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "nasne_hdd_usage_bytes", Help: "h"}, []string{"name"})
+	g.WithLabelValues("nasne1").Set(1)
+	g.WithLabelValues("living room/1").Set(2)
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "nasne_events_total", Help: "h"})
+	r.MustRegister(g, c)
+
+	p := NewPushgateway(slog.New(slog.DiscardHandler), url+"/", "nasne exporter", 0)
+	if err := p.Push(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Names with "/" are encoded in base64.
+	want := map[string][]string{
+		"/metrics/job/nasne%20exporter":                                {"nasne_events_total"},
+		"/metrics/job/nasne%20exporter/name/nasne1":                    {"nasne_hdd_usage_bytes"},
+		"/metrics/job/nasne%20exporter/name@base64/bGl2aW5nIHJvb20vMQ": {"nasne_hdd_usage_bytes"},
+	}
+	if len(gw.requests) != len(want) {
+		t.Fatalf("received %d requests, want %d: %+v", len(gw.requests), len(want), gw.requests)
+	}
+	for _, req := range gw.requests {
+		if req.method != http.MethodPut {
+			t.Errorf("method = %v, want PUT", req.method)
+		}
+		families, ok := want[req.path]
+		if !ok {
+			t.Errorf("unexpected path %v", req.path)
+			continue
+		}
+		if len(req.families) != 1 || req.families[0] != families[0] {
+			t.Errorf("families of %v = %v, want %v", req.path, req.families, families)
+		}
+	}
+}
+
+func TestPushgatewayRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantErr      bool
+	}{
+		{
+			name:         "retry on 5xx",
+			statuses:     []int{http.StatusServiceUnavailable},
+			wantRequests: 2,
+		},
+		{
+			name:         "no retry on 4xx",
+			statuses:     []int{http.StatusBadRequest},
+			wantRequests: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw, url := newGateway(t, tt.statuses...)
+
+			r := prometheus.NewRegistry()
+			g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "nasne_hdd_usage_bytes", Help: "h"}, []string{"name"})
+			g.WithLabelValues("nasne1").Set(1)
+			r.MustRegister(g)
+
+			p := NewPushgateway(slog.New(slog.DiscardHandler), url, "nasne", 1)
+			err := p.Push(context.Background(), r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Push() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(gw.requests) != tt.wantRequests {
+				t.Errorf("received %d requests, want %d", len(gw.requests), tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/hatotaka/nasne_exporter/pkg/retry"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type RemoteWriter struct {
+	url        string
+	retry      int
+	bufferSize int
+	client     *http.Client
+	logger     *slog.Logger
+
+	// buffer holds the series which have not been sent yet.
+	buffer []*TimeSeries
+}
+
+// NewRemoteWriter returns a Pusher which sends metrics to url with the
+// Prometheus remote write protocol. Series which could not be sent are kept
+// up to bufferSize and sent with the next push.
+func NewRemoteWriter(logger *slog.Logger, url string, retry, bufferSize int) *RemoteWriter {
+	return &RemoteWriter{
+		url:        url,
+		retry:      retry,
+		bufferSize: bufferSize,
+		client:     &http.Client{Timeout: requestTimeout},
+		logger:     logger,
+	}
+}
+
+func (r *RemoteWriter) Push(ctx context.Context, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	r.buffer = append(r.buffer, toTimeSeries(mfs, time.Now())...)
+	if dropped := len(r.buffer) - r.bufferSize; dropped > 0 {
+		r.logger.Warn("drop series from the remote write buffer", "series", dropped)
+		r.buffer = r.buffer[dropped:]
+	}
+
+	data, err := proto.Marshal(&WriteRequest{Timeseries: r.buffer})
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, data)
+
+	r.logger.Debug("remote write", "series", len(r.buffer), "url", r.url)
+
+	err = retry.Do(ctx, r.logger, r.retry, func() error {
+		return r.post(ctx, body)
+	})
+	if retry.IsRecoverable(err) {
+		return err
+	}
+
+	// The series are dropped on an unrecoverable error too, because the
+	// receiver would never accept them.
+	r.buffer = nil
+
+	return err
+}
+
+func (r *RemoteWriter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	res, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return retry.Recoverable(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	return retry.CheckResponseStatus(r.url, res.StatusCode)
+}
+
+// toTimeSeries converts metric families to series of the remote write
+// protocol. Summaries and histograms are expanded the same way as the text
+// format.
+func toTimeSeries(mfs []*dto.MetricFamily, now time.Time) []*TimeSeries {
+	var tss []*TimeSeries
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+
+		for _, m := range mf.Metric {
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, extra ...*Label) {
+				tss = append(tss, &TimeSeries{
+					Labels:  toLabels(name, m.Label, extra...),
+					Samples: []*Sample{{Value: value, Timestamp: ts}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, q.GetValue(), &Label{Name: "quantile", Value: formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				var hasInf bool
+				for _, b := range h.Bucket {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), &Label{Name: "le", Value: formatFloat(b.GetUpperBound())})
+					hasInf = hasInf || math.IsInf(b.GetUpperBound(), 1)
+				}
+				if !hasInf {
+					add(name+"_bucket", float64(h.GetSampleCount()), &Label{Name: "le", Value: "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+
+	return tss
+}
+
+// toLabels returns the labels sorted by name, which the remote write protocol requires.
+func toLabels(name string, lps []*dto.LabelPair, extra ...*Label) []*Label {
+	labels := []*Label{{Name: "__name__", Value: name}}
+	for _, lp := range lps {
+		labels = append(labels, &Label{Name: lp.GetName(), Value: lp.GetValue()})
+	}
+	labels = append(labels, extra...)
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
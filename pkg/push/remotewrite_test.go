@@ -0,0 +1,208 @@
+package push
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// receiver is a remote write endpoint which decodes the requests and responds
+// with the next status of statuses, or 204 if none is left.
+type receiver struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*WriteRequest
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	headers := map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+	for k, want := range headers {
+		if got := r.Header.Get(k); got != want {
+			rc.t.Errorf("%v = %q, want %q", k, got, want)
+		}
+	}
+
+	compressed, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("failed to read body: %v", err)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		rc.t.Errorf("invalid snappy body: %v", err)
+	}
+	var req WriteRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		rc.t.Errorf("invalid WriteRequest: %v", err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.requests = append(rc.requests, &req)
+
+	status := http.StatusNoContent
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	rc := &receiver{t: t, statuses: statuses}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	return rc, srv.URL
+}
+
+// newGatherer returns a registry with a gauge of 2 series and a counter.
+func newGatherer() prometheus.Gatherer {
+	r := prometheus.NewRegistry()
+
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "nasne_hdd_usage_bytes", Help: "h"}, []string{"name", "id"})
+	g.WithLabelValues("nasne1", "0").Set(1024)
+	g.WithLabelValues("nasne1", "1").Set(2048)
+
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "nasne_events_total", Help: "h"})
+	c.Add(3)
+
+	r.MustRegister(g, c)
+	return r
+}
+
+// series returns the series of req as label sets with values.
+func series(req *WriteRequest) map[string]float64 {
+	ss := map[string]float64{}
+	for _, ts := range req.Timeseries {
+		var key string
+		for _, l := range ts.Labels {
+			key += l.Name + "=" + l.Value + ","
+		}
+		for _, s := range ts.Samples {
+			ss[key] = s.Value
+		}
+	}
+	return ss
+}
+
+func TestRemoteWriterPush(t *testing.T) {
+	rc, url := newReceiver(t)
+
+	rw := NewRemoteWriter(slog.New(slog.DiscardHandler), url, 0, 100)
+	if err := rw.Push(context.Background(), newGatherer()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("received %d requests, want 1", len(rc.requests))
+	}
+	req := rc.requests[0]
+
+	// The labels are sorted by name.
+	want := map[string]float64{
+		"__name__=nasne_events_total,":                     3,
+		"__name__=nasne_hdd_usage_bytes,id=0,name=nasne1,": 1024,
+		"__name__=nasne_hdd_usage_bytes,id=1,name=nasne1,": 2048,
+	}
+	if got := series(req); !reflect.DeepEqual(got, want) {
+		t.Errorf("series = %v, want %v", got, want)
+	}
+
+	for _, ts := range req.Timeseries {
+		if len(ts.Samples) != 1 || ts.Samples[0].Timestamp == 0 {
+			t.Errorf("samples of %v = %v, want 1 sample with timestamp", ts.Labels, ts.Samples)
+		}
+	}
+}
+
+func TestRemoteWriterRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retry        int
+		wantErr      bool
+		wantRequests int
+		wantBuffered int
+	}{
+		{
+			name:         "retry on 5xx",
+			statuses:     []int{http.StatusInternalServerError},
+			retry:        1,
+			wantRequests: 2,
+		},
+		{
+			name:         "retry on 429",
+			statuses:     []int{http.StatusTooManyRequests},
+			retry:        1,
+			wantRequests: 2,
+		},
+		{
+			name:         "keep series after retries",
+			statuses:     []int{http.StatusServiceUnavailable},
+			retry:        0,
+			wantErr:      true,
+			wantRequests: 1,
+			wantBuffered: 3,
+		},
+		{
+			name:         "no retry on 4xx",
+			statuses:     []int{http.StatusBadRequest},
+			retry:        1,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, url := newReceiver(t, tt.statuses...)
+
+			rw := NewRemoteWriter(slog.New(slog.DiscardHandler), url, tt.retry, 100)
+			err := rw.Push(context.Background(), newGatherer())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(rc.requests) != tt.wantRequests {
+				t.Errorf("received %d requests, want %d", len(rc.requests), tt.wantRequests)
+			}
+			if len(rw.buffer) != tt.wantBuffered {
+				t.Errorf("buffered %d series, want %d", len(rw.buffer), tt.wantBuffered)
+			}
+		})
+	}
+}
+
+func TestRemoteWriterBuffer(t *testing.T) {
+	rc, url := newReceiver(t, http.StatusServiceUnavailable)
+
+	rw := NewRemoteWriter(slog.New(slog.DiscardHandler), url, 0, 4)
+	if err := rw.Push(context.Background(), newGatherer()); err == nil {
+		t.Fatal("Push() succeeded with status 503, want error")
+	}
+	if err := rw.Push(context.Background(), newGatherer()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest series are dropped to fit the buffer.
+	if got := len(rc.requests[1].Timeseries); got != 4 {
+		t.Errorf("sent %d series, want 4", got)
+	}
+	if len(rw.buffer) != 0 {
+		t.Errorf("buffered %d series after success, want 0", len(rw.buffer))
+	}
+}
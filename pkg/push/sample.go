@@ -0,0 +1,77 @@
+package push
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TagMapping renames labels to the tags of InfluxDB and Graphite. Labels
+// mapped to the empty string are dropped. Labels not in the mapping are kept
+// as they are.
+type TagMapping map[string]string
+
+// sample is a value of a series with the labels mapped to tags.
+type sample struct {
+	name  string
+	tags  []*Label
+	value float64
+	// timestamp is in milliseconds.
+	timestamp int64
+}
+
+// toSamples converts metric families to samples in the same way as the remote
+// write protocol. Samples which are NaN or infinite are dropped, because
+// neither InfluxDB nor Graphite accepts them.
+func toSamples(mfs []*dto.MetricFamily, now time.Time, mapping TagMapping) []*sample {
+	var samples []*sample
+
+	for _, ts := range toTimeSeries(mfs, now) {
+		s := ts.Samples[0]
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+
+		smp := &sample{value: s.Value, timestamp: s.Timestamp}
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				smp.name = l.Value
+				continue
+			}
+
+			name := l.Name
+			if mapped, ok := mapping[name]; ok {
+				name = mapped
+			}
+			// Neither InfluxDB nor Graphite accepts empty tag values.
+			if name == "" || l.Value == "" {
+				continue
+			}
+			smp.tags = append(smp.tags, &Label{Name: name, Value: l.Value})
+		}
+		sort.Slice(smp.tags, func(i, j int) bool {
+			return smp.tags[i].Name < smp.tags[j].Name
+		})
+
+		samples = append(samples, smp)
+	}
+
+	return samples
+}
+
+// batches splits lines into batches of up to size lines. A size of 0 or less
+// means a single batch.
+func batches(lines []string, size int) [][]string {
+	if size <= 0 || len(lines) <= size {
+		return [][]string{lines}
+	}
+
+	var bs [][]string
+	for len(lines) > size {
+		bs = append(bs, lines[:size])
+		lines = lines[size:]
+	}
+	return append(bs, lines)
+}
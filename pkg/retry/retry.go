@@ -0,0 +1,70 @@
+// Package retry retries requests to external services with exponential
+// backoff.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const interval = time.Second
+
+// recoverableError is an error which is worth retrying.
+type recoverableError struct {
+	error
+}
+
+// Recoverable marks err as worth retrying.
+func Recoverable(err error) error {
+	return recoverableError{err}
+}
+
+// IsRecoverable returns whether err is worth retrying.
+func IsRecoverable(err error) bool {
+	_, ok := err.(recoverableError)
+	return ok
+}
+
+// Do calls f until it succeeds, it returns an unrecoverable error, it has been
+// retried retry times or ctx is canceled. The interval between retries is
+// doubled each time.
+func Do(ctx context.Context, logger *slog.Logger, retry int, f func() error) error {
+	interval := interval
+
+	var err error
+	for i := 0; ; i++ {
+		err = f()
+		if err == nil {
+			return nil
+		}
+		if !IsRecoverable(err) || i >= retry {
+			break
+		}
+
+		logger.Warn("request failed, retrying", "interval", interval, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+
+	return err
+}
+
+// CheckResponseStatus returns an error if code is not successful. 5xx and 429
+// are recoverable.
+func CheckResponseStatus(url string, code int) error {
+	switch {
+	case code/100 == 2:
+		return nil
+	case code/100 == 5 || code == 429:
+		return Recoverable(fmt.Errorf("unexpected status code %d from %v", code, url))
+	default:
+		return fmt.Errorf("unexpected status code %d from %v", code, url)
+	}
+}
@@ -0,0 +1,284 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTLPExporter exports spans to url, such as "http://localhost:4318/v1/traces",
+// with the JSON encoding of OTLP/HTTP.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+	logger *slog.Logger
+}
+
+func NewOTLPExporter(logger *slog.Logger, url string) *OTLPExporter {
+	return &OTLPExporter{
+		url:    url,
+		client: &http.Client{},
+		logger: logger,
+	}
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	body, err := json.Marshal(toTracesData(spans))
+	if err != nil {
+		return err
+	}
+
+	e.logger.Debug("export spans", "spans", len(spans), "url", e.url)
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d from %v", res.StatusCode, e.url)
+	}
+
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// The types below are the JSON encoding of the OTLP trace messages.
+// See https://github.com/open-telemetry/opentelemetry-proto.
+
+type tracesData struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resourceJSON `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resourceJSON struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []keyValue  `json:"attributes,omitempty"`
+	Events            []eventJSON `json:"events,omitempty"`
+	Status            statusJSON  `json:"status"`
+}
+
+type eventJSON struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []keyValue `json:"attributes,omitempty"`
+}
+
+type statusJSON struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+func toTracesData(spans []sdktrace.ReadOnlySpan) tracesData {
+	var data tracesData
+
+	// Spans are grouped by resource and then by instrumentation scope.
+	resourceIndex := map[attribute.Distinct]int{}
+	scopeIndex := map[attribute.Distinct]map[string]int{}
+	for _, s := range spans {
+		res := s.Resource()
+		var key attribute.Distinct
+		if res != nil {
+			key = res.Equivalent()
+		}
+
+		ri, ok := resourceIndex[key]
+		if !ok {
+			rs := resourceSpans{}
+			if res != nil {
+				rs.Resource.Attributes = toKeyValues(res.Attributes())
+			}
+			data.ResourceSpans = append(data.ResourceSpans, rs)
+			ri = len(data.ResourceSpans) - 1
+			resourceIndex[key] = ri
+			scopeIndex[key] = map[string]int{}
+		}
+		rs := &data.ResourceSpans[ri]
+
+		is := s.InstrumentationScope()
+		si, ok := scopeIndex[key][is.Name+"\x00"+is.Version]
+		if !ok {
+			rs.ScopeSpans = append(rs.ScopeSpans, scopeSpans{Scope: scope{Name: is.Name, Version: is.Version}})
+			si = len(rs.ScopeSpans) - 1
+			scopeIndex[key][is.Name+"\x00"+is.Version] = si
+		}
+
+		rs.ScopeSpans[si].Spans = append(rs.ScopeSpans[si].Spans, toSpan(s))
+	}
+
+	return data
+}
+
+func toSpan(s sdktrace.ReadOnlySpan) spanJSON {
+	sc := s.SpanContext()
+	span := spanJSON{
+		TraceID:           sc.TraceID().String(),
+		SpanID:            sc.SpanID().String(),
+		Name:              s.Name(),
+		Kind:              toSpanKind(s.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+		Attributes:        toKeyValues(s.Attributes()),
+		Status:            toStatus(s.Status()),
+	}
+	if s.Parent().IsValid() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+
+	for _, e := range s.Events() {
+		span.Events = append(span.Events, eventJSON{
+			TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+			Name:         e.Name,
+			Attributes:   toKeyValues(e.Attributes),
+		})
+	}
+
+	return span
+}
+
+// toSpanKind converts kind to the value of SpanKind of OTLP, which is
+// different from trace.SpanKind for unspecified.
+func toSpanKind(kind trace.SpanKind) int {
+	switch kind {
+	case trace.SpanKindInternal:
+		return 1
+	case trace.SpanKindServer:
+		return 2
+	case trace.SpanKindClient:
+		return 3
+	case trace.SpanKindProducer:
+		return 4
+	case trace.SpanKindConsumer:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// toStatus converts s to the Status of OTLP, in which the values of Ok and
+// Error are swapped from codes.Code.
+func toStatus(s sdktrace.Status) statusJSON {
+	switch s.Code {
+	case codes.Ok:
+		return statusJSON{Code: 1}
+	case codes.Error:
+		return statusJSON{Code: 2, Message: s.Description}
+	default:
+		return statusJSON{}
+	}
+}
+
+func toKeyValues(attrs []attribute.KeyValue) []keyValue {
+	var kvs []keyValue
+	for _, a := range attrs {
+		kvs = append(kvs, keyValue{Key: string(a.Key), Value: toAnyValue(a.Value)})
+	}
+	return kvs
+}
+
+func toAnyValue(v attribute.Value) anyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		return anyValue{BoolValue: &b}
+	case attribute.INT64:
+		i := strconv.FormatInt(v.AsInt64(), 10)
+		return anyValue{IntValue: &i}
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		return anyValue{DoubleValue: &f}
+	case attribute.STRING:
+		s := v.AsString()
+		return anyValue{StringValue: &s}
+	case attribute.BOOLSLICE:
+		var values []anyValue
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, toAnyValue(attribute.BoolValue(b)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case attribute.INT64SLICE:
+		var values []anyValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, toAnyValue(attribute.Int64Value(i)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case attribute.FLOAT64SLICE:
+		var values []anyValue
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, toAnyValue(attribute.Float64Value(f)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case attribute.STRINGSLICE:
+		var values []anyValue
+		for _, s := range v.AsStringSlice() {
+			values = append(values, toAnyValue(attribute.StringValue(s)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	default:
+		s := v.Emit()
+		return anyValue{StringValue: &s}
+	}
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newCollector returns a server which records the bodies of the requests and
+// responds with status.
+func newCollector(t *testing.T, status int) (*httptest.Server, *[]tracesData) {
+	t.Helper()
+
+	var received []tracesData
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %v, want POST", r.Method)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read body: %v", err)
+		}
+		var data tracesData
+		if err := json.Unmarshal(body, &data); err != nil {
+			t.Errorf("invalid body %s: %v", body, err)
+		}
+		received = append(received, data)
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &received
+}
+
+func TestOTLPExporter(t *testing.T) {
+	srv, received := newCollector(t, http.StatusOK)
+
+	exporter := NewOTLPExporter(slog.New(slog.DiscardHandler), srv.URL)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	tracer := tp.Tracer("test", trace.WithInstrumentationVersion("v1"))
+
+	ctx, root := tracer.Start(context.Background(), "collect")
+	_, child := tracer.Start(ctx, "collect.box",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("net.peer.name", "192.0.2.1"),
+			attribute.Int("nasne.port", 64210),
+			attribute.Bool("nasne.stale", true),
+		),
+	)
+	child.RecordError(errors.New("timeout"))
+	child.SetStatus(codes.Error, "timeout")
+	child.End()
+	root.End()
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// WithSyncer exports each span when it ends.
+	if len(*received) != 2 {
+		t.Fatalf("received %d requests, want 2", len(*received))
+	}
+
+	var spans []spanJSON
+	for _, data := range *received {
+		if len(data.ResourceSpans) != 1 {
+			t.Fatalf("resourceSpans = %+v, want 1 element", data.ResourceSpans)
+		}
+		rs := data.ResourceSpans[0]
+
+		attrs := rs.Resource.Attributes
+		if len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue == nil || *attrs[0].Value.StringValue != serviceName {
+			t.Errorf("resource attributes = %+v, want service.name=%v", attrs, serviceName)
+		}
+
+		if len(rs.ScopeSpans) != 1 {
+			t.Fatalf("scopeSpans = %+v, want 1 element", rs.ScopeSpans)
+		}
+		if got, want := rs.ScopeSpans[0].Scope, (scope{Name: "test", Version: "v1"}); got != want {
+			t.Errorf("scope = %+v, want %+v", got, want)
+		}
+
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+
+	childJSON, rootJSON := spans[0], spans[1]
+	rootSC, childSC := root.SpanContext(), child.SpanContext()
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"root name", rootJSON.Name, "collect"},
+		{"root traceId", rootJSON.TraceID, rootSC.TraceID().String()},
+		{"root spanId", rootJSON.SpanID, rootSC.SpanID().String()},
+		{"root parentSpanId", rootJSON.ParentSpanID, ""},
+		{"root kind", rootJSON.Kind, 1},
+		{"root status", rootJSON.Status, statusJSON{}},
+		{"child name", childJSON.Name, "collect.box"},
+		{"child traceId", childJSON.TraceID, rootSC.TraceID().String()},
+		{"child spanId", childJSON.SpanID, childSC.SpanID().String()},
+		{"child parentSpanId", childJSON.ParentSpanID, rootSC.SpanID().String()},
+		{"child kind", childJSON.Kind, 3},
+		{"child status", childJSON.Status, statusJSON{Code: 2, Message: "timeout"}},
+		{"child events", len(childJSON.Events), 1},
+		{"traceId length", len(rootJSON.TraceID), 32},
+		{"spanId length", len(rootJSON.SpanID), 16},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	attrs := map[string]anyValue{}
+	for _, kv := range childJSON.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["net.peer.name"].StringValue; v == nil || *v != "192.0.2.1" {
+		t.Errorf("net.peer.name = %v, want 192.0.2.1", v)
+	}
+	if v := attrs["nasne.port"].IntValue; v == nil || *v != "64210" {
+		t.Errorf("nasne.port = %v, want \"64210\"", v)
+	}
+	if v := attrs["nasne.stale"].BoolValue; v == nil || !*v {
+		t.Errorf("nasne.stale = %v, want true", v)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	srv, _ := newCollector(t, http.StatusServiceUnavailable)
+
+	exporter := NewOTLPExporter(slog.New(slog.DiscardHandler), srv.URL)
+	if err := exporter.ExportSpans(context.Background(), nil); err == nil {
+		t.Error("ExportSpans succeeded with status 503, want error")
+	}
+}
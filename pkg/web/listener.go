@@ -0,0 +1,108 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const unixPrefix = "unix:"
+
+// Listen returns listeners for addrs. An address prefixed with "unix:" is
+// the path of a Unix domain socket, and the others are TCP addresses such as
+// "192.0.2.1:8080" and "[::1]:8080".
+func Listen(addrs []string) ([]net.Listener, error) {
+	var ls []net.Listener
+	for _, addr := range addrs {
+		l, err := listen(addr)
+		if err != nil {
+			closeListeners(ls)
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+
+	return ls, nil
+}
+
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, unixPrefix)
+
+	// Remove the socket left by the previous process. Other files are kept
+	// so that a wrong path does not remove them.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// systemdListenFDsStart is the first file descriptor passed by systemd.
+const systemdListenFDsStart = 3
+
+// SystemdListeners returns the listeners passed by systemd socket activation.
+func SystemdListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets are passed by systemd")
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("no sockets are passed by systemd")
+	}
+
+	var ls []net.Listener
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(ls)
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+
+	return ls, nil
+}
+
+// Serve serves srv on all listeners and returns when any of them fails.
+// TLS is enabled if srv.TLSConfig is set.
+func Serve(srv *http.Server, ls []net.Listener) error {
+	errCh := make(chan error, len(ls))
+
+	// Serve sets srv.TLSConfig to configure HTTP/2, so check it beforehand.
+	useTLS := srv.TLSConfig != nil
+
+	for _, l := range ls {
+		go func(l net.Listener) {
+			if useTLS {
+				errCh <- srv.ServeTLS(l, "", "")
+			} else {
+				errCh <- srv.Serve(l)
+			}
+		}(l)
+	}
+
+	return <-errCh
+}
+
+func closeListeners(ls []net.Listener) {
+	for _, l := range ls {
+		l.Close()
+	}
+}
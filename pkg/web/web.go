@@ -0,0 +1,180 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"go.yaml.in/yaml/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the web config file, compatible with the one of
+// prometheus/exporter-toolkit.
+type Config struct {
+	TLSConfig TLSConfig         `yaml:"tls_server_config"`
+	Users     map[string]string `yaml:"basic_auth_users"`
+}
+
+type TLSConfig struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ClientAuth string `yaml:"client_auth_type"`
+	ClientCAs  string `yaml:"client_ca_file"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+
+	if (c.TLSConfig.CertFile == "") != (c.TLSConfig.KeyFile == "") {
+		return nil, fmt.Errorf("both cert_file and key_file must be set in %v", path)
+	}
+	if _, ok := clientAuthTypes[c.TLSConfig.ClientAuth]; !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q in %v", c.TLSConfig.ClientAuth, path)
+	}
+	if c.TLSConfig.ClientCAs != "" && c.TLSConfig.ClientAuth == "" {
+		return nil, fmt.Errorf("client_ca_file is set without client_auth_type in %v", path)
+	}
+	// The server would run without TLS, although client certificates are
+	// required.
+	if c.TLSConfig.CertFile == "" && (c.TLSConfig.ClientAuth != "" || c.TLSConfig.ClientCAs != "") {
+		return nil, fmt.Errorf("client_auth_type and client_ca_file require cert_file in %v", path)
+	}
+	// Client certificates would be verified with the system roots, which
+	// accept any publicly issued certificate.
+	switch clientAuthTypes[c.TLSConfig.ClientAuth] {
+	case tls.VerifyClientCertIfGiven, tls.RequireAndVerifyClientCert:
+		if c.TLSConfig.ClientCAs == "" {
+			return nil, fmt.Errorf("client_ca_file is required for client_auth_type %v in %v", c.TLSConfig.ClientAuth, path)
+		}
+	}
+
+	return c, nil
+}
+
+// TLS returns the TLS configuration of the server. It returns nil if TLS is
+// not configured.
+func (c *Config) TLS() (*tls.Config, error) {
+	if c.TLSConfig.CertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLSConfig.CertFile, c.TLSConfig.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   clientAuthTypes[c.TLSConfig.ClientAuth],
+	}
+
+	if c.TLSConfig.ClientCAs != "" {
+		pem, err := ioutil.ReadFile(c.TLSConfig.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", c.TLSConfig.ClientCAs)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// Handler wraps h with basic authentication if users are configured.
+func (c *Config) Handler(h http.Handler, logger *slog.Logger) http.Handler {
+	if len(c.Users) == 0 {
+		return h
+	}
+
+	return &basicAuthHandler{
+		handler: h,
+		users:   c.Users,
+		logger:  logger,
+		cache:   map[[sha256.Size]byte]bool{},
+	}
+}
+
+const maxCacheSize = 100
+
+// dummyHash is compared when the user does not exist, so that the response
+// time does not tell whether the user exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+type basicAuthHandler struct {
+	handler http.Handler
+	users   map[string]string
+	logger  *slog.Logger
+
+	// cache holds the results of bcrypt, which is too slow to run on every scrape.
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]bool
+}
+
+func (b *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if ok && b.authenticate(user, pass) {
+		b.handler.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="nasne_exporter"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (b *basicAuthHandler) authenticate(user, pass string) bool {
+	hash, exists := b.users[user]
+	if !exists {
+		hash = string(dummyHash)
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hash))
+
+	b.mu.Lock()
+	authOK, cached := b.cache[key]
+	b.mu.Unlock()
+
+	if !cached {
+		authOK = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+
+		b.mu.Lock()
+		if len(b.cache) >= maxCacheSize {
+			b.cache = map[[sha256.Size]byte]bool{}
+		}
+		b.cache[key] = authOK
+		b.mu.Unlock()
+	}
+
+	if !exists || !authOK {
+		b.logger.Debug("basic authentication failed", "user", user)
+		return false
+	}
+
+	return true
+}
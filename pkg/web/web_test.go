@@ -0,0 +1,233 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCert writes a self-signed certificate and its key to dir.
+func writeCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nasne_exporter"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir)
+
+	tests := []struct {
+		name       string
+		yaml       string
+		wantErr    string
+		clientAuth tls.ClientAuthType
+		clientCAs  bool
+	}{
+		{
+			name: "empty",
+			yaml: "",
+		},
+		{
+			name: "TLS",
+			yaml: "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n",
+		},
+		{
+			name:       "client certificates",
+			yaml:       "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: " + certFile + "\n",
+			clientAuth: tls.RequireAndVerifyClientCert,
+			clientCAs:  true,
+		},
+		{
+			name:       "client certificates without verification",
+			yaml:       "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n  client_auth_type: RequireAnyClientCert\n",
+			clientAuth: tls.RequireAnyClientCert,
+		},
+		{
+			name:    "unknown field",
+			yaml:    "tls_config: {}\n",
+			wantErr: "failed to parse",
+		},
+		{
+			name:    "cert without key",
+			yaml:    "tls_server_config:\n  cert_file: " + certFile + "\n",
+			wantErr: "both cert_file and key_file",
+		},
+		{
+			name:    "invalid client_auth_type",
+			yaml:    "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n  client_auth_type: Always\n",
+			wantErr: "invalid client_auth_type",
+		},
+		{
+			name:    "client_ca_file without client_auth_type",
+			yaml:    "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n  client_ca_file: " + certFile + "\n",
+			wantErr: "without client_auth_type",
+		},
+		{
+			name:    "client_auth_type without cert_file",
+			yaml:    "tls_server_config:\n  client_auth_type: RequireAnyClientCert\n",
+			wantErr: "require cert_file",
+		},
+		{
+			name:    "client certificates without cert_file",
+			yaml:    "tls_server_config:\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: " + certFile + "\n",
+			wantErr: "require cert_file",
+		},
+		{
+			name:    "verification without client_ca_file",
+			yaml:    "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n  client_auth_type: RequireAndVerifyClientCert\n",
+			wantErr: "client_ca_file is required",
+		},
+		{
+			name:    "verification if given without client_ca_file",
+			yaml:    "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n  client_auth_type: VerifyClientCertIfGiven\n",
+			wantErr: "client_ca_file is required",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "web"+string(rune('a'+i))+".yml")
+			if err := ioutil.WriteFile(path, []byte(tt.yaml), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := LoadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := c.TLS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.TLSConfig.CertFile == "" {
+				if cfg != nil {
+					t.Errorf("TLS() = %v, want nil without cert_file", cfg)
+				}
+				return
+			}
+			if cfg.ClientAuth != tt.clientAuth {
+				t.Errorf("ClientAuth = %v, want %v", cfg.ClientAuth, tt.clientAuth)
+			}
+			if (cfg.ClientCAs != nil) != tt.clientCAs {
+				t.Errorf("ClientCAs = %v, want set %v", cfg.ClientCAs, tt.clientCAs)
+			}
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{Users: map[string]string{
+		"alice": string(hash),
+		"bob":   "not a bcrypt hash",
+	}}
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		noHeader bool
+		want     int
+	}{
+		{name: "valid", user: "alice", password: "secret", want: http.StatusOK},
+		{name: "wrong password", user: "alice", password: "wrong", want: http.StatusUnauthorized},
+		{name: "unknown user", user: "carol", password: "secret", want: http.StatusUnauthorized},
+		{name: "bad hash", user: "bob", password: "not a bcrypt hash", want: http.StatusUnauthorized},
+		{name: "missing header", noHeader: true, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The second request is answered from the cache.
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				if !tt.noHeader {
+					req.SetBasicAuth(tt.user, tt.password)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				if rec.Code != tt.want {
+					t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+				}
+				auth := rec.Header().Get("WWW-Authenticate")
+				if tt.want == http.StatusUnauthorized && auth != `Basic realm="nasne_exporter"` {
+					t.Errorf("WWW-Authenticate = %q, want the basic realm", auth)
+				}
+				if tt.want == http.StatusOK && rec.Body.String() != "ok" {
+					t.Errorf("body = %q, want the handler response", rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerWithoutUsers(t *testing.T) {
+	h := http.NotFoundHandler()
+	if got := (&Config{}).Handler(h, slog.New(slog.DiscardHandler)); got == nil {
+		t.Fatal("Handler() = nil")
+	} else if _, ok := got.(*basicAuthHandler); ok {
+		t.Error("Handler() requires authentication without users")
+	}
+}